@@ -2,7 +2,10 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -17,16 +20,103 @@ type RegistryMapping struct {
 	AuthHost string `toml:"authHost"`
 	AuthType string `toml:"authType"`
 	Enabled  bool   `toml:"enabled"`
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	// Public 允许所有客户端经该映射使用 Username/Password 拉取；HubProxy 不校验客户端身份，未开启时凭据不生效
+	Public bool `toml:"public"`
+	// Aliases 路径中可代替域名的短别名，如 k8s、gh
+	Aliases []string `toml:"aliases"`
+	// SkipDigestVerify 跳过 blob 传输时的 digest 校验
+	SkipDigestVerify bool `toml:"skipDigestVerify"`
+	// Scheme 为 "http" 时以明文 HTTP 访问上游与认证端点，默认 HTTPS
+	Scheme             string `toml:"scheme"`
+	CAFile             string `toml:"caFile"`
+	CertFile           string `toml:"certFile"`
+	KeyFile            string `toml:"keyFile"`
+	InsecureSkipVerify bool   `toml:"insecureSkipVerify"`
+	// 仓库名改写：依次去除 StripPrefix、补全 DefaultNamespace、应用 Rewrite 规则，最后加上 PathPrefix
+	StripPrefix      string        `toml:"stripPrefix"`
+	DefaultNamespace string        `toml:"defaultNamespace"`
+	Rewrite          []RewriteRule `toml:"rewrite"`
+	PathPrefix       string        `toml:"pathPrefix"`
+}
+
+// RewriteRule 仓库名正则改写规则，Replace 支持 $1 等分组引用
+type RewriteRule struct {
+	Match   string `toml:"match"`
+	Replace string `toml:"replace"`
+}
+
+// HostRoute 按请求 Host 的路由配置
+type HostRoute struct {
+	// Registry 该 Host 的 /v2 请求直接转发到的 Registry 域名（[registries] 中的键），留空按路径识别
+	Registry string `toml:"registry"`
+	// Serve 该 Host 承载的流量：all（默认）、registry（/v2 与 /token）或 github（文件加速）
+	Serve string `toml:"serve"`
+}
+
+// WebhookConfig webhook 订阅配置
+type WebhookConfig struct {
+	URL         string   `toml:"url"`
+	Secret      string   `toml:"secret"`
+	Events      []string `toml:"events"`
+	MaxRetries  int      `toml:"maxRetries"`
+	Timeout     string   `toml:"timeout"`
+	MinExportMB int      `toml:"minExportMB"`
+}
+
+// TLSConfig 原生 TLS 终止配置，证书来自静态文件或 ACME
+type TLSConfig struct {
+	Enabled  bool   `toml:"enabled"`
+	CertFile string `toml:"certFile"`
+	KeyFile  string `toml:"keyFile"`
+	// HTTPPort 额外监听的明文端口，用于 ACME HTTP-01 质询与 HTTP→HTTPS 跳转，0 表示不监听
+	HTTPPort int `toml:"httpPort"`
+	// RedirectHTTP 明文端口上的请求跳转到 HTTPS，关闭时明文端口同样提供服务
+	RedirectHTTP bool `toml:"redirectHTTP"`
+	// HTTP3 在同一端口的 UDP 上额外提供 HTTP/3（QUIC），并通过 Alt-Svc 声明
+	HTTP3 bool       `toml:"http3"`
+	ACME  ACMEConfig `toml:"acme"`
+}
+
+// ACMEConfig ACME 自动签发证书配置，同时支持 HTTP-01 与 TLS-ALPN-01 质询
+type ACMEConfig struct {
+	Enabled bool     `toml:"enabled"`
+	Domains []string `toml:"domains"`
+	Email   string   `toml:"email"`
+	// DirectoryURL ACME 目录地址，默认 Let's Encrypt 生产环境
+	DirectoryURL string `toml:"directoryURL"`
+	// CAFile 访问 ACME 目录时额外信任的 CA（如本地 Pebble）
+	CAFile   string `toml:"caFile"`
+	CacheDir string `toml:"cacheDir"`
+}
+
+// EgressRule 出站规则，按上游主机或 Registry 映射选择代理池或直连
+type EgressRule struct {
+	// Hosts 匹配的上游主机名，支持 path.Match 通配符，如 "*.docker.io"
+	Hosts []string `toml:"hosts"`
+	// Registries 匹配的 Registry 域名（[registries] 中的键或 docker.io），覆盖其上游与认证端点
+	Registries []string `toml:"registries"`
+	// Proxies 代理池（http/https/socks5/socks5h），为空表示直连
+	Proxies []string `toml:"proxies"`
+	// Strategy 代理池选择策略：round-robin（默认）或 failover
+	Strategy string `toml:"strategy"`
 }
 
 // AppConfig 应用配置结构体
 type AppConfig struct {
 	Server struct {
-		Host           string `toml:"host"`
-		Port           int    `toml:"port"`
-		FileSize       int64  `toml:"fileSize"`
-		EnableH2C      bool   `toml:"enableH2C"`
-		EnableFrontend bool   `toml:"enableFrontend"`
+		Host                 string    `toml:"host"`
+		Port                 int       `toml:"port"`
+		FileSize             int64     `toml:"fileSize"`
+		EnableH2C            bool      `toml:"enableH2C"`
+		EnableFrontend       bool      `toml:"enableFrontend"`
+		PublicURL            string    `toml:"publicURL"`
+		BasePath             string    `toml:"basePath"`
+		ShutdownDelay        string    `toml:"shutdownDelay"`
+		DrainTimeout         string    `toml:"drainTimeout"`
+		TLS                  TLSConfig `toml:"tls"`
+		CheckAliasCollisions bool      `toml:"checkAliasCollisions"`
 	} `toml:"server"`
 
 	RateLimit struct {
@@ -45,16 +135,58 @@ type AppConfig struct {
 		Proxy     string   `toml:"proxy"`
 	} `toml:"access"`
 
+	Egress struct {
+		HealthCheckInterval string       `toml:"healthCheckInterval"`
+		HealthCheckTimeout  string       `toml:"healthCheckTimeout"`
+		Rules               []EgressRule `toml:"rules"`
+	} `toml:"egress"`
+
 	Download struct {
-		MaxImages int `toml:"maxImages"`
+		MaxImages          int `toml:"maxImages"`
+		CompressionLevel   int `toml:"compressionLevel"`
+		CompressionThreads int `toml:"compressionThreads"`
 	} `toml:"download"`
 
 	Registries map[string]RegistryMapping `toml:"registries"`
 
+	Hosts map[string]HostRoute `toml:"hosts"`
+
+	Passthrough struct {
+		Enabled bool     `toml:"enabled"`
+		Allow   []string `toml:"allow"`
+		Deny    []string `toml:"deny"`
+	} `toml:"passthrough"`
+
 	TokenCache struct {
-		Enabled    bool   `toml:"enabled"`
-		DefaultTTL string `toml:"defaultTTL"`
+		Enabled            bool   `toml:"enabled"`
+		DefaultTTL         string `toml:"defaultTTL"`
+		CacheAuthenticated bool   `toml:"cacheAuthenticated"`
 	} `toml:"tokenCache"`
+
+	Cache struct {
+		MaxMemoryMB      int            `toml:"maxMemoryMB"`
+		QuotasMB         map[string]int `toml:"quotasMB"`
+		StaleGracePeriod string         `toml:"staleGracePeriod"`
+		Offline          bool           `toml:"offline"`
+	} `toml:"cache"`
+
+	Admin struct {
+		Token string `toml:"token"`
+	} `toml:"admin"`
+
+	Prefetch struct {
+		Images    []string `toml:"images"`
+		Platforms []string `toml:"platforms"`
+	} `toml:"prefetch"`
+
+	Watch struct {
+		Schedule    string   `toml:"schedule"`
+		Images      []string `toml:"images"`
+		Platforms   []string `toml:"platforms"`
+		HistorySize int      `toml:"historySize"`
+	} `toml:"watch"`
+
+	Webhooks []WebhookConfig `toml:"webhooks"`
 }
 
 var (
@@ -71,17 +203,32 @@ var (
 func DefaultConfig() *AppConfig {
 	return &AppConfig{
 		Server: struct {
-			Host           string `toml:"host"`
-			Port           int    `toml:"port"`
-			FileSize       int64  `toml:"fileSize"`
-			EnableH2C      bool   `toml:"enableH2C"`
-			EnableFrontend bool   `toml:"enableFrontend"`
+			Host                 string    `toml:"host"`
+			Port                 int       `toml:"port"`
+			FileSize             int64     `toml:"fileSize"`
+			EnableH2C            bool      `toml:"enableH2C"`
+			EnableFrontend       bool      `toml:"enableFrontend"`
+			PublicURL            string    `toml:"publicURL"`
+			BasePath             string    `toml:"basePath"`
+			ShutdownDelay        string    `toml:"shutdownDelay"`
+			DrainTimeout         string    `toml:"drainTimeout"`
+			TLS                  TLSConfig `toml:"tls"`
+			CheckAliasCollisions bool      `toml:"checkAliasCollisions"`
 		}{
 			Host:           "0.0.0.0",
 			Port:           5000,
 			FileSize:       2 * 1024 * 1024 * 1024,
 			EnableH2C:      false,
 			EnableFrontend: true,
+			ShutdownDelay:  "5s",
+			DrainTimeout:   "60s",
+			TLS: TLSConfig{
+				RedirectHTTP: true,
+				ACME: ACMEConfig{
+					CacheDir: "./certs",
+				},
+			},
+			CheckAliasCollisions: true,
 		},
 		RateLimit: struct {
 			RequestLimit int     `toml:"requestLimit"`
@@ -106,10 +253,23 @@ func DefaultConfig() *AppConfig {
 			BlackList: []string{},
 			Proxy:     "",
 		},
+		Egress: struct {
+			HealthCheckInterval string       `toml:"healthCheckInterval"`
+			HealthCheckTimeout  string       `toml:"healthCheckTimeout"`
+			Rules               []EgressRule `toml:"rules"`
+		}{
+			HealthCheckInterval: "30s",
+			HealthCheckTimeout:  "5s",
+			Rules:               []EgressRule{},
+		},
 		Download: struct {
-			MaxImages int `toml:"maxImages"`
+			MaxImages          int `toml:"maxImages"`
+			CompressionLevel   int `toml:"compressionLevel"`
+			CompressionThreads int `toml:"compressionThreads"`
 		}{
-			MaxImages: 10,
+			MaxImages:          10,
+			CompressionLevel:   0,
+			CompressionThreads: 0,
 		},
 		Registries: map[string]RegistryMapping{
 			"ghcr.io": {
@@ -137,13 +297,64 @@ func DefaultConfig() *AppConfig {
 				Enabled:  true,
 			},
 		},
+		Hosts: map[string]HostRoute{},
+		Passthrough: struct {
+			Enabled bool     `toml:"enabled"`
+			Allow   []string `toml:"allow"`
+			Deny    []string `toml:"deny"`
+		}{
+			Enabled: false,
+			Allow:   []string{},
+			Deny:    []string{},
+		},
 		TokenCache: struct {
-			Enabled    bool   `toml:"enabled"`
-			DefaultTTL string `toml:"defaultTTL"`
+			Enabled            bool   `toml:"enabled"`
+			DefaultTTL         string `toml:"defaultTTL"`
+			CacheAuthenticated bool   `toml:"cacheAuthenticated"`
 		}{
-			Enabled:    true,
-			DefaultTTL: "20m",
+			Enabled:            true,
+			DefaultTTL:         "20m",
+			CacheAuthenticated: true,
 		},
+		Cache: struct {
+			MaxMemoryMB      int            `toml:"maxMemoryMB"`
+			QuotasMB         map[string]int `toml:"quotasMB"`
+			StaleGracePeriod string         `toml:"staleGracePeriod"`
+			Offline          bool           `toml:"offline"`
+		}{
+			MaxMemoryMB:      256,
+			StaleGracePeriod: "24h",
+			QuotasMB: map[string]int{
+				"tokens":    16,
+				"manifests": 160,
+				"search":    32,
+				"tags":      48,
+			},
+		},
+		Admin: struct {
+			Token string `toml:"token"`
+		}{
+			Token: "",
+		},
+		Prefetch: struct {
+			Images    []string `toml:"images"`
+			Platforms []string `toml:"platforms"`
+		}{
+			Images:    []string{},
+			Platforms: []string{},
+		},
+		Watch: struct {
+			Schedule    string   `toml:"schedule"`
+			Images      []string `toml:"images"`
+			Platforms   []string `toml:"platforms"`
+			HistorySize int      `toml:"historySize"`
+		}{
+			Schedule:    "*/15 * * * *",
+			Images:      []string{},
+			Platforms:   []string{},
+			HistorySize: 20,
+		},
+		Webhooks: []WebhookConfig{},
 	}
 }
 
@@ -174,10 +385,18 @@ func GetConfig() *AppConfig {
 	}
 
 	configCopy := *appConfig
+	configCopy.Server.TLS.ACME.Domains = append([]string(nil), appConfig.Server.TLS.ACME.Domains...)
 	configCopy.Security.WhiteList = append([]string(nil), appConfig.Security.WhiteList...)
 	configCopy.Security.BlackList = append([]string(nil), appConfig.Security.BlackList...)
 	configCopy.Access.WhiteList = append([]string(nil), appConfig.Access.WhiteList...)
 	configCopy.Access.BlackList = append([]string(nil), appConfig.Access.BlackList...)
+	configCopy.Egress.Rules = append([]EgressRule(nil), appConfig.Egress.Rules...)
+	configCopy.Passthrough.Allow = append([]string(nil), appConfig.Passthrough.Allow...)
+	configCopy.Passthrough.Deny = append([]string(nil), appConfig.Passthrough.Deny...)
+	configCopy.Prefetch.Images = append([]string(nil), appConfig.Prefetch.Images...)
+	configCopy.Prefetch.Platforms = append([]string(nil), appConfig.Prefetch.Platforms...)
+	configCopy.Watch.Images = append([]string(nil), appConfig.Watch.Images...)
+	configCopy.Watch.Platforms = append([]string(nil), appConfig.Watch.Platforms...)
 	appConfigLock.RUnlock()
 
 	cachedConfig = &configCopy
@@ -217,11 +436,176 @@ func LoadConfig() error {
 	}
 
 	overrideFromEnv(cfg)
+	basePath, err := normalizeBasePath(cfg.Server.BasePath)
+	if err != nil {
+		return err
+	}
+	cfg.Server.BasePath = basePath
+	if err := validateTLS(cfg.Server.TLS); err != nil {
+		return err
+	}
+	if err := validateRegistryAliases(cfg.Registries); err != nil {
+		return err
+	}
+	if err := validateRegistryRewrites(cfg.Registries); err != nil {
+		return err
+	}
+	for domain, mapping := range cfg.Registries {
+		if mapping.Username != "" && !mapping.Public {
+			fmt.Printf("Registry %s 配置了账号但未设置 public = true，拉取时不会使用该凭据\n", domain)
+		}
+	}
+	if err := validateEgress(cfg); err != nil {
+		return err
+	}
 	setConfig(cfg)
 
 	return nil
 }
 
+// validateTLS 检查启用 TLS 时证书来源是否唯一且完整
+func validateTLS(tlsCfg TLSConfig) error {
+	if !tlsCfg.Enabled {
+		if tlsCfg.HTTP3 {
+			return fmt.Errorf("server.tls.http3 需要启用 server.tls")
+		}
+		return nil
+	}
+	static := tlsCfg.CertFile != "" || tlsCfg.KeyFile != ""
+	switch {
+	case static && tlsCfg.ACME.Enabled:
+		return fmt.Errorf("server.tls 不能同时配置 certFile/keyFile 与 acme")
+	case static && (tlsCfg.CertFile == "" || tlsCfg.KeyFile == ""):
+		return fmt.Errorf("server.tls 的 certFile 与 keyFile 必须同时配置")
+	case tlsCfg.ACME.Enabled && len(tlsCfg.ACME.Domains) == 0:
+		return fmt.Errorf("server.tls.acme 需要至少配置一个域名")
+	case !static && !tlsCfg.ACME.Enabled:
+		return fmt.Errorf("server.tls 已启用，但未配置证书文件或 acme")
+	}
+	return nil
+}
+
+// validateRegistryRewrites 预先编译各 Registry 的仓库名改写正则，避免错误规则到拉取时才暴露
+func validateRegistryRewrites(registries map[string]RegistryMapping) error {
+	for domain, mapping := range registries {
+		for i, rule := range mapping.Rewrite {
+			if _, err := regexp.Compile(rule.Match); err != nil {
+				return fmt.Errorf("Registry %s 的 rewrite[%d] 正则 %q 无效: %v", domain, i, rule.Match, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateEgress 检查出站代理地址、选择策略、健康检查时长以及规则引用的 Registry
+func validateEgress(cfg *AppConfig) error {
+	if cfg.Access.Proxy != "" {
+		if _, err := ParseProxyURL(cfg.Access.Proxy); err != nil {
+			return fmt.Errorf("access.proxy: %w", err)
+		}
+	}
+	for name, value := range map[string]string{
+		"healthCheckInterval": cfg.Egress.HealthCheckInterval,
+		"healthCheckTimeout":  cfg.Egress.HealthCheckTimeout,
+	} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d < 0 {
+			return fmt.Errorf("egress.%s %q 无效", name, value)
+		}
+	}
+	for i, rule := range cfg.Egress.Rules {
+		if len(rule.Hosts) == 0 && len(rule.Registries) == 0 {
+			return fmt.Errorf("egress.rules[%d] 需要配置 hosts 或 registries", i)
+		}
+		for _, pattern := range rule.Hosts {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("egress.rules[%d] 的主机模式 %q 无效", i, pattern)
+			}
+		}
+		for _, registry := range rule.Registries {
+			if _, ok := cfg.Registries[registry]; !ok && registry != "docker.io" {
+				return fmt.Errorf("egress.rules[%d] 引用的 Registry %q 未在 [registries] 中配置", i, registry)
+			}
+		}
+		for _, proxy := range rule.Proxies {
+			if _, err := ParseProxyURL(proxy); err != nil {
+				return fmt.Errorf("egress.rules[%d]: %w", i, err)
+			}
+		}
+		switch rule.Strategy {
+		case "", "round-robin", "failover":
+		default:
+			return fmt.Errorf("egress.rules[%d] 的策略 %q 无效，可选 round-robin 或 failover", i, rule.Strategy)
+		}
+	}
+	return nil
+}
+
+// ParseProxyURL 解析出站代理地址，省略 scheme 时按 HTTP 代理处理
+func ParseProxyURL(raw string) (*url.URL, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("代理地址 %q 无效: %v", raw, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("代理地址 %q 的协议 %q 不受支持", raw, u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("代理地址 %q 缺少主机", raw)
+	}
+	return u, nil
+}
+
+// normalizeBasePath 规范化路由前缀为 "/hub" 形式，根路径返回空串
+func normalizeBasePath(basePath string) (string, error) {
+	basePath = strings.Trim(strings.TrimSpace(basePath), "/")
+	if basePath == "" {
+		return "", nil
+	}
+	basePath = "/" + basePath
+	if strings.ContainsAny(basePath, "?#\\ ") || strings.Contains(basePath, "//") || strings.Contains(basePath, "..") {
+		return "", fmt.Errorf("basePath %q 无效", basePath)
+	}
+	if basePath == "/v2" || strings.HasPrefix(basePath, "/v2/") {
+		return "", fmt.Errorf("basePath %q 与 Registry /v2 路由冲突", basePath)
+	}
+	return basePath, nil
+}
+
+// validateRegistryAliases 检查别名格式，以及别名之间、别名与 Registry 域名之间的冲突。
+// library 是官方镜像的命名空间，占用后 nginx 等短名将无法经本代理拉取，因此直接拒绝；
+// 与其他 Docker Hub 命名空间的冲突由启动后的在线检查提示
+func validateRegistryAliases(registries map[string]RegistryMapping) error {
+	owners := make(map[string]string)
+	for domain := range registries {
+		owners[strings.ToLower(domain)] = domain
+	}
+	for domain, mapping := range registries {
+		for _, alias := range mapping.Aliases {
+			key := strings.ToLower(strings.TrimSpace(alias))
+			if key == "" || strings.ContainsAny(key, "/.:") {
+				return fmt.Errorf("Registry %s 的别名 %q 无效：不能为空或包含 / . :", domain, alias)
+			}
+			if key == "library" {
+				return fmt.Errorf("Registry %s 的别名 %q 与 Docker Hub 命名空间冲突", domain, alias)
+			}
+			if owner, exists := owners[key]; exists && owner != domain {
+				return fmt.Errorf("Registry %s 的别名 %q 与 %s 冲突", domain, alias, owner)
+			}
+			owners[key] = domain
+		}
+	}
+	return nil
+}
+
 // overrideFromEnv 从环境变量覆盖配置
 func overrideFromEnv(cfg *AppConfig) {
 	if val := os.Getenv("SERVER_HOST"); val != "" {
@@ -232,6 +616,12 @@ func overrideFromEnv(cfg *AppConfig) {
 			cfg.Server.Port = port
 		}
 	}
+	if val, ok := os.LookupEnv("PUBLIC_URL"); ok {
+		cfg.Server.PublicURL = strings.TrimSpace(val)
+	}
+	if val, ok := os.LookupEnv("BASE_PATH"); ok {
+		cfg.Server.BasePath = val
+	}
 	if val := os.Getenv("ENABLE_H2C"); val != "" {
 		if enable, err := strconv.ParseBool(val); err == nil {
 			cfg.Server.EnableH2C = enable
@@ -275,4 +665,14 @@ func overrideFromEnv(cfg *AppConfig) {
 			cfg.Download.MaxImages = maxImages
 		}
 	}
+
+	if val, ok := os.LookupEnv("ADMIN_TOKEN"); ok {
+		cfg.Admin.Token = strings.TrimSpace(val)
+	}
+
+	if val := os.Getenv("OFFLINE_MODE"); val != "" {
+		if offline, err := strconv.ParseBool(val); err == nil {
+			cfg.Cache.Offline = offline
+		}
+	}
 }
@@ -39,3 +39,122 @@ proxy = "socks5://127.0.0.1:1080"
 		t.Fatalf("Access.Proxy = %q, want empty override", cfg.Access.Proxy)
 	}
 }
+
+func TestValidateRegistryAliases(t *testing.T) {
+	valid := map[string]RegistryMapping{
+		"registry.k8s.io": {Aliases: []string{"k8s"}},
+		"ghcr.io":         {Aliases: []string{"gh"}},
+	}
+	if err := validateRegistryAliases(valid); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []map[string]RegistryMapping{
+		{"ghcr.io": {Aliases: []string{"library"}}},
+		{"ghcr.io": {Aliases: []string{"gh.io"}}},
+		{"ghcr.io": {Aliases: []string{"gh"}}, "gcr.io": {Aliases: []string{"GH"}}},
+		{"ghcr.io": {Aliases: []string{"quay.io"}}, "quay.io": {}},
+	}
+	for i, registries := range invalid {
+		if err := validateRegistryAliases(registries); err == nil {
+			t.Fatalf("case %d: expected alias conflict", i)
+		}
+	}
+}
+
+func TestNormalizeBasePath(t *testing.T) {
+	for in, want := range map[string]string{
+		"":           "",
+		"/":          "",
+		"hub":        "/hub",
+		"/hub/":      "/hub",
+		"/tools/hub": "/tools/hub",
+	} {
+		got, err := normalizeBasePath(in)
+		if err != nil || got != want {
+			t.Fatalf("normalizeBasePath(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"/v2", "/v2/hub", "/a/../b", "/a?b"} {
+		if _, err := normalizeBasePath(in); err == nil {
+			t.Fatalf("normalizeBasePath(%q): expected error", in)
+		}
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	valid := []TLSConfig{
+		{},
+		{Enabled: true, CertFile: "cert.pem", KeyFile: "key.pem"},
+		{Enabled: true, ACME: ACMEConfig{Enabled: true, Domains: []string{"hub.example.com"}}},
+	}
+	for i, tlsCfg := range valid {
+		if err := validateTLS(tlsCfg); err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+	}
+
+	invalid := []TLSConfig{
+		{HTTP3: true},
+		{Enabled: true},
+		{Enabled: true, CertFile: "cert.pem"},
+		{Enabled: true, ACME: ACMEConfig{Enabled: true}},
+		{Enabled: true, CertFile: "cert.pem", KeyFile: "key.pem", ACME: ACMEConfig{Enabled: true, Domains: []string{"hub.example.com"}}},
+	}
+	for i, tlsCfg := range invalid {
+		if err := validateTLS(tlsCfg); err == nil {
+			t.Fatalf("case %d: expected error", i)
+		}
+	}
+}
+
+func TestValidateEgress(t *testing.T) {
+	valid := []EgressRule{
+		{Hosts: []string{"*.gcr.io"}, Proxies: []string{"socks5://127.0.0.1:1080", "proxy.internal:3128"}},
+		{Registries: []string{"docker.io", "ghcr.io"}, Strategy: "failover"},
+	}
+	invalid := []EgressRule{
+		{Proxies: []string{"socks5://127.0.0.1:1080"}},
+		{Hosts: []string{"[gcr.io"}},
+		{Registries: []string{"unknown.example.com"}},
+		{Hosts: []string{"gcr.io"}, Proxies: []string{"ftp://127.0.0.1:21"}},
+		{Hosts: []string{"gcr.io"}, Strategy: "random"},
+	}
+
+	for i, rule := range valid {
+		cfg := DefaultConfig()
+		cfg.Egress.Rules = []EgressRule{rule}
+		if err := validateEgress(cfg); err != nil {
+			t.Fatalf("valid case %d: %v", i, err)
+		}
+	}
+	for i, rule := range invalid {
+		cfg := DefaultConfig()
+		cfg.Egress.Rules = []EgressRule{rule}
+		if err := validateEgress(cfg); err == nil {
+			t.Fatalf("invalid case %d: expected error", i)
+		}
+	}
+
+	cfg := DefaultConfig()
+	cfg.Egress.HealthCheckInterval = "soon"
+	if err := validateEgress(cfg); err == nil {
+		t.Fatal("expected error for invalid healthCheckInterval")
+	}
+}
+
+func TestValidateRegistryRewrites(t *testing.T) {
+	valid := map[string]RegistryMapping{
+		"harbor.local": {Rewrite: []RewriteRule{{Match: "^library/(.+)$", Replace: "dockerhub/$1"}}},
+	}
+	if err := validateRegistryRewrites(valid); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := map[string]RegistryMapping{
+		"harbor.local": {Rewrite: []RewriteRule{{Match: "^library/(.+$", Replace: "$1"}}},
+	}
+	if err := validateRegistryRewrites(invalid); err == nil {
+		t.Fatal("expected error for invalid rewrite pattern")
+	}
+}
@@ -1,17 +1,26 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"hubproxy/config"
 	"hubproxy/utils"
 )
@@ -31,11 +40,19 @@ type RegistryDetector struct{}
 func (rd *RegistryDetector) detectRegistryDomain(c *gin.Context, path string) (string, string) {
 	cfg := config.GetConfig()
 
+	// 绑定了 Registry 的 Host 不需要路径前缀
+	if domain, ok := hostRegistry(c); ok {
+		return domain, path
+	}
+
 	// 兼容Containerd的ns参数
 	if ns := c.Query("ns"); ns != "" {
 		if mapping, exists := cfg.Registries[ns]; exists && mapping.Enabled {
 			return ns, path
 		}
+		if domain, ok := rd.lookupAlias(ns); ok {
+			return domain, path
+		}
 	}
 
 	for domain := range cfg.Registries {
@@ -45,27 +62,643 @@ func (rd *RegistryDetector) detectRegistryDomain(c *gin.Context, path string) (s
 		}
 	}
 
+	if first, rest, ok := strings.Cut(path, "/"); ok {
+		if domain, found := rd.lookupAlias(first); found {
+			return domain, rest
+		}
+		if passthroughAllowed(first) {
+			return first, rest
+		}
+	}
+
 	return "", path
 }
 
+// lookupAlias 查找别名对应的已启用 Registry 域名
+func (rd *RegistryDetector) lookupAlias(alias string) (string, bool) {
+	cfg := config.GetConfig()
+	for domain, mapping := range cfg.Registries {
+		if !mapping.Enabled {
+			continue
+		}
+		for _, a := range mapping.Aliases {
+			if strings.EqualFold(strings.TrimSpace(a), alias) {
+				return domain, true
+			}
+		}
+	}
+	return "", false
+}
+
+// expandRegistryAlias 将以别名开头的镜像引用（如 k8s/kube-apiserver:v1.30.0）展开为完整的 Registry 域名
+func expandRegistryAlias(image string) string {
+	first, rest, ok := strings.Cut(image, "/")
+	if !ok {
+		return image
+	}
+	if domain, found := registryDetector.lookupAlias(first); found {
+		return domain + "/" + rest
+	}
+	return image
+}
+
 // isRegistryEnabled 检查Registry是否启用
 func (rd *RegistryDetector) isRegistryEnabled(domain string) bool {
 	cfg := config.GetConfig()
 	if mapping, exists := cfg.Registries[domain]; exists {
 		return mapping.Enabled
 	}
-	return false
+	return passthroughAllowed(domain)
 }
 
 // getRegistryMapping 获取Registry映射配置
 func (rd *RegistryDetector) getRegistryMapping(domain string) (config.RegistryMapping, bool) {
 	cfg := config.GetConfig()
-	mapping, exists := cfg.Registries[domain]
-	return mapping, exists && mapping.Enabled
+	if mapping, exists := cfg.Registries[domain]; exists {
+		return mapping, mapping.Enabled
+	}
+	if passthroughAllowed(domain) {
+		return passthroughMapping(domain), true
+	}
+	return config.RegistryMapping{}, false
 }
 
 var registryDetector = &RegistryDetector{}
 
+// dockerHubNamespaceURL 查询 Docker Hub 命名空间下仓库列表的接口
+var dockerHubNamespaceURL = "https://registry.hub.docker.com/v2/repositories/%s/?page_size=1"
+
+// ReportAliasCollisions 启动后查询 Docker Hub，检查 Registry 别名是否与已存在的命名空间同名，离线模式下跳过。
+// 别名优先，同名命名空间经本代理拉取时会被转发到别名对应的 Registry，因此只记录警告
+func ReportAliasCollisions() {
+	if utils.IsOfflineMode() {
+		return
+	}
+
+	cfg := config.GetConfig()
+	for domain, mapping := range cfg.Registries {
+		if !mapping.Enabled {
+			continue
+		}
+		for _, alias := range mapping.Aliases {
+			alias = strings.ToLower(strings.TrimSpace(alias))
+			exists, err := dockerHubNamespaceExists(alias)
+			if err != nil {
+				fmt.Printf("检查别名 %s 与 Docker Hub 命名空间是否冲突失败: %v\n", alias, err)
+				continue
+			}
+			if exists {
+				fmt.Printf("警告: Registry %s 的别名 %s 与 Docker Hub 命名空间同名，%s/* 将被转发到 %s\n", domain, alias, alias, domain)
+			}
+		}
+	}
+}
+
+// dockerHubNamespaceExists 判断 Docker Hub 上是否存在含仓库的同名命名空间
+func dockerHubNamespaceExists(namespace string) (bool, error) {
+	resp, err := utils.GetSearchHTTPClient().Get(fmt.Sprintf(dockerHubNamespaceURL, namespace))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("Docker Hub 返回状态码 %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Count int `json:"count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Count > 0, nil
+}
+
+// upstreamTarget 镜像仓库在上游的实际位置及拉取选项
+type upstreamTarget struct {
+	repository name.Repository
+	accessName string
+	options    []remote.Option
+	cacheScope string
+}
+
+// contextOptions 返回附带请求上下文的拉取选项
+func (t *upstreamTarget) contextOptions(ctx context.Context) []remote.Option {
+	return append(t.options[:len(t.options):len(t.options)], remote.WithContext(ctx))
+}
+
+// resolveUpstreamTarget 按 [registries] 映射解析镜像仓库，与 /v2 代理使用相同的上游地址与选项
+func resolveUpstreamTarget(repo name.Repository) (*upstreamTarget, error) {
+	domain := repo.RegistryStr()
+	repository := repo.RepositoryStr()
+
+	if domain == name.DefaultRegistry {
+		upstream, err := name.NewRepository(fmt.Sprintf("%s/%s", dockerProxy.registry.Name(), repository))
+		if err != nil {
+			return nil, err
+		}
+		return &upstreamTarget{
+			repository: upstream,
+			accessName: repository,
+			options:    dockerProxy.options,
+			cacheScope: dockerHubCacheScope,
+		}, nil
+	}
+
+	mapping, ok := registryDetector.getRegistryMapping(domain)
+	if !ok {
+		return nil, fmt.Errorf("Registry %s 未配置或未启用", domain)
+	}
+
+	normalized, upstreamRef, err := upstreamRepository(mapping, repository)
+	if err != nil {
+		return nil, err
+	}
+	upstream, err := name.NewRepository(upstreamRef, upstreamNameOptions(upstreamRef)...)
+	if err != nil {
+		return nil, err
+	}
+	return &upstreamTarget{
+		repository: upstream,
+		accessName: domain + "/" + normalized,
+		options:    createUpstreamOptions(mapping),
+		cacheScope: blobCacheScope(mapping),
+	}, nil
+}
+
+// upstreamNameOptions 镜像所在的上游配置了 scheme = "http" 时，让 ggcr 以明文 HTTP 访问该 Registry
+func upstreamNameOptions(imageRef string) []name.Option {
+	host, _, _ := strings.Cut(imageRef, "/")
+	for _, mapping := range config.GetConfig().Registries {
+		if !mapping.Enabled || !strings.EqualFold(mapping.Scheme, "http") {
+			continue
+		}
+		if upstreamHost, _, _ := strings.Cut(mapping.Upstream, "/"); upstreamHost == host {
+			return []name.Option{name.Insecure}
+		}
+	}
+	return nil
+}
+
+// manifestAcceptKey 请求上下文中客户端 Accept 列表的key
+type manifestAcceptKey struct{}
+
+// manifestETagKey 请求上下文中用于上游条件请求的 digest 的key
+type manifestETagKey struct{}
+
+// withManifestAccept 在上下文中记录客户端可接受的 manifest 类型，由 upstreamTransport 转发给上游
+func withManifestAccept(ctx context.Context, accept []string) context.Context {
+	if len(accept) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, manifestAcceptKey{}, accept)
+}
+
+// withManifestETag 在上下文中记录已缓存的 digest，上游请求附带 If-None-Match 以便返回 304
+func withManifestETag(ctx context.Context, digest string) context.Context {
+	return context.WithValue(ctx, manifestETagKey{}, digest)
+}
+
+// errOfflineMode 离线模式下拒绝访问上游
+var errOfflineMode = errors.New("离线模式下不访问上游")
+
+// upstreamTransport ggcr 访问上游的统一出口：离线模式下拒绝请求；拉取 manifest 时用客户端的 Accept
+// 替换 ggcr 默认列表，并附带条件请求头；请求结果计入上游健康状态
+type upstreamTransport struct {
+	base http.RoundTripper
+}
+
+func (t *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if utils.IsOfflineMode() {
+		return nil, errOfflineMode
+	}
+	if strings.Contains(req.URL.Path, "/manifests/") {
+		accept, hasAccept := req.Context().Value(manifestAcceptKey{}).([]string)
+		digest, hasETag := req.Context().Value(manifestETagKey{}).(string)
+		if hasAccept || hasETag {
+			req = req.Clone(req.Context())
+			if hasAccept {
+				req.Header.Set("Accept", strings.Join(accept, ", "))
+			}
+			if hasETag {
+				req.Header.Set("If-None-Match", `"`+digest+`"`)
+			}
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	upstreamHealthTracker.observe(req, resp, err)
+	return resp, err
+}
+
+// isUpstreamUnavailable 判断错误是否为上游故障（网络错误、5xx、429），此时可使用宽限期内的过期缓存
+func isUpstreamUnavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, errOfflineMode) {
+		return false
+	}
+	var terr *transport.Error
+	if errors.As(err, &terr) {
+		return terr.StatusCode >= http.StatusInternalServerError || terr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// isNotModified 判断上游是否以 304 响应条件请求
+func isNotModified(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusNotModified
+}
+
+// etagMatches 判断 If-None-Match 是否包含该 digest
+func etagMatches(ifNoneMatch, digest string) bool {
+	if ifNoneMatch == "" || digest == "" {
+		return false
+	}
+	for _, part := range strings.Split(ifNoneMatch, ",") {
+		tag := strings.TrimSpace(part)
+		if tag == "*" {
+			return true
+		}
+		tag = strings.Trim(strings.TrimPrefix(tag, "W/"), `"`)
+		if tag == digest {
+			return true
+		}
+	}
+	return false
+}
+
+// writeManifestResponse 输出 manifest，客户端 If-None-Match 与 digest 一致时返回 304
+func writeManifestResponse(c *gin.Context, item *utils.CachedItem) {
+	digest := item.Headers["Docker-Content-Digest"]
+	if digest != "" {
+		c.Header("ETag", `"`+digest+`"`)
+		if etagMatches(c.GetHeader("If-None-Match"), digest) {
+			c.Header("Docker-Content-Digest", digest)
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+	utils.WriteCachedResponse(c, item)
+}
+
+// writeManifestHead 输出 manifest HEAD 响应头，规则同 writeManifestResponse
+func writeManifestHead(c *gin.Context, desc *v1.Descriptor) {
+	digest := desc.Digest.String()
+	c.Header("Docker-Content-Digest", digest)
+	c.Header("ETag", `"`+digest+`"`)
+	if etagMatches(c.GetHeader("If-None-Match"), digest) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Header("Content-Type", string(desc.MediaType))
+	c.Header("Content-Length", fmt.Sprintf("%d", desc.Size))
+	c.Status(http.StatusOK)
+}
+
+// parseManifestAccept 解析客户端 Accept 头并忽略 q 等参数；未声明或包含 */* 时返回 nil，表示接受任意类型
+func parseManifestAccept(header http.Header) []string {
+	var accept []string
+	for _, value := range header.Values("Accept") {
+		for _, part := range strings.Split(value, ",") {
+			mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+			if mediaType == "*/*" {
+				return nil
+			}
+			if mediaType != "" {
+				accept = append(accept, mediaType)
+			}
+		}
+	}
+	return accept
+}
+
+// acceptsMediaType 判断 Accept 列表是否接受该媒体类型，nil 表示接受任意类型
+func acceptsMediaType(accept []string, mediaType string) bool {
+	if accept == nil {
+		return true
+	}
+	for _, item := range accept {
+		if item == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// indexMediaTypes 多架构索引类型，客户端接受时上游优先返回索引
+var indexMediaTypes = []string{string(types.OCIImageIndex), string(types.DockerManifestList)}
+
+// imageMediaTypes 单平台 manifest 类型
+var imageMediaTypes = []string{
+	string(types.OCIManifestSchema1),
+	string(types.DockerManifestSchema2),
+	string(types.DockerManifestSchema1Signed),
+	string(types.DockerManifestSchema1),
+}
+
+func acceptsIndex(accept []string) bool {
+	for _, mediaType := range indexMediaTypes {
+		if acceptsMediaType(accept, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// manifestTagIndex tag 在各媒体类型下对应的 manifest digest
+type manifestTagIndex struct {
+	Digests map[string]string `json:"digests"`
+	// Single 为 true 表示客户端接受索引类型时上游仍返回单平台 manifest
+	Single bool `json:"single"`
+}
+
+// selectDigest 按客户端 Accept 选择已缓存的 digest，无法确定上游会返回哪种类型时返回空
+func (idx *manifestTagIndex) selectDigest(accept []string) string {
+	candidates := imageMediaTypes
+	if acceptsIndex(accept) && !idx.Single {
+		candidates = indexMediaTypes
+	}
+	for _, mediaType := range candidates {
+		if digest := idx.Digests[mediaType]; digest != "" && acceptsMediaType(accept, mediaType) {
+			return digest
+		}
+	}
+	return ""
+}
+
+// loadManifestTagIndex 读取 tag 索引，已过期的索引仍会返回用于条件请求，同时返回索引的过期时间
+func loadManifestTagIndex(imageRef, tag string) (*manifestTagIndex, time.Time) {
+	idx := &manifestTagIndex{Digests: make(map[string]string)}
+	item, _ := utils.GlobalCache.GetWithStale(utils.BuildManifestTagCacheKey(imageRef, tag))
+	if item == nil {
+		return idx, time.Time{}
+	}
+	if err := json.Unmarshal(item.Data, idx); err != nil || idx.Digests == nil {
+		return &manifestTagIndex{Digests: make(map[string]string)}, time.Time{}
+	}
+	return idx, item.ExpiresAt
+}
+
+// storeManifestTagIndex 记录 tag 在该媒体类型下的 digest
+func storeManifestTagIndex(imageRef, tag string, accept []string, mediaType, digest string) {
+	idx, _ := loadManifestTagIndex(imageRef, tag)
+	isIndex := false
+	for _, indexType := range indexMediaTypes {
+		if mediaType == indexType {
+			isIndex = true
+		}
+	}
+	if isIndex {
+		idx.Single = false
+	} else if acceptsIndex(accept) {
+		idx.Single = true
+	}
+	idx.Digests[mediaType] = digest
+
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return
+	}
+	utils.GlobalCache.Set(utils.BuildManifestTagCacheKey(imageRef, tag), data, "application/json", nil, utils.GetManifestTTL(tag))
+}
+
+// lookupCachedManifest 按客户端 Accept 查找缓存：digest 直接命中，tag 先经 tag→digest 索引；
+// fresh 为 false 时条目已过期，需向上游条件请求确认
+func lookupCachedManifest(imageRef, reference string, accept []string) (*utils.CachedItem, bool) {
+	digest := reference
+	var indexExpiresAt time.Time
+	if !strings.HasPrefix(reference, "sha256:") {
+		var idx *manifestTagIndex
+		idx, indexExpiresAt = loadManifestTagIndex(imageRef, reference)
+		digest = idx.selectDigest(accept)
+		if digest == "" {
+			return nil, false
+		}
+	}
+
+	item, _ := utils.GlobalCache.GetWithStale(utils.BuildManifestCacheKey(imageRef, digest))
+	if item == nil || !acceptsMediaType(accept, item.ContentType) {
+		return nil, false
+	}
+	// tag 的有效期以索引与 manifest 中较早过期者为准
+	if !indexExpiresAt.IsZero() && indexExpiresAt.Before(item.ExpiresAt) {
+		expiring := *item
+		expiring.ExpiresAt = indexExpiresAt
+		item = &expiring
+	}
+	return item, time.Now().Before(item.ExpiresAt)
+}
+
+// storeManifest 按 digest 写入 manifest 并更新 tag 索引
+func storeManifest(imageRef, reference string, accept []string, item *utils.CachedItem) {
+	digest := item.Headers["Docker-Content-Digest"]
+	utils.GlobalCache.Set(utils.BuildManifestCacheKey(imageRef, digest), item.Data, item.ContentType, item.Headers, utils.GetManifestTTL(digest))
+	if !strings.HasPrefix(reference, "sha256:") {
+		storeManifestTagIndex(imageRef, reference, accept, item.ContentType, digest)
+	}
+}
+
+// fetchManifest 获取manifest，按 Accept 命中与 /v2 代理共享的缓存时不访问上游；accept 为 nil 时使用 ggcr 默认列表
+func fetchManifest(ctx context.Context, imageRef, reference string, accept []string, options []remote.Option) (*utils.CachedItem, error) {
+	var staleItem *utils.CachedItem
+	if utils.IsCacheEnabled() {
+		cachedItem, fresh := lookupCachedManifest(imageRef, reference, accept)
+		if cachedItem != nil && (fresh || utils.IsOfflineMode()) {
+			return cachedItem, nil
+		}
+		staleItem = cachedItem
+	}
+	if utils.IsOfflineMode() {
+		return nil, fmt.Errorf("%s:%s 未缓存: %w", imageRef, reference, errOfflineMode)
+	}
+
+	var ref name.Reference
+	var err error
+	if strings.HasPrefix(reference, "sha256:") {
+		ref, err = name.NewDigest(fmt.Sprintf("%s@%s", imageRef, reference), upstreamNameOptions(imageRef)...)
+	} else {
+		ref, err = name.NewTag(fmt.Sprintf("%s:%s", imageRef, reference), upstreamNameOptions(imageRef)...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析镜像引用失败: %w", err)
+	}
+
+	upstreamCtx := withManifestAccept(ctx, accept)
+	if staleItem != nil {
+		upstreamCtx = withManifestETag(upstreamCtx, staleItem.Headers["Docker-Content-Digest"])
+	}
+
+	desc, err := remote.Get(ref, append(options[:len(options):len(options)], remote.WithContext(upstreamCtx))...)
+	if staleItem != nil && isNotModified(err) {
+		storeManifest(imageRef, reference, accept, staleItem)
+		return staleItem, nil
+	}
+	if err != nil {
+		if staleItem != nil && isUpstreamUnavailable(err) && staleItem.WithinStaleGrace() {
+			fmt.Printf("上游不可用，返回过期的manifest缓存 %s:%s: %v\n", imageRef, reference, err)
+			return staleItem, nil
+		}
+		return nil, err
+	}
+
+	item := &utils.CachedItem{
+		Data:        desc.Manifest,
+		ContentType: string(desc.MediaType),
+		Headers: map[string]string{
+			"Docker-Content-Digest": desc.Digest.String(),
+			"Content-Length":        fmt.Sprintf("%d", len(desc.Manifest)),
+		},
+	}
+
+	if utils.IsCacheEnabled() {
+		storeManifest(imageRef, reference, accept, item)
+	}
+
+	return item, nil
+}
+
+// headManifest 查询 manifest 描述；离线模式只查缓存，上游故障时退回宽限期内的过期缓存
+func headManifest(ctx context.Context, ref name.Reference, imageRef, reference string, accept []string, options []remote.Option) (*v1.Descriptor, error) {
+	var err error
+	if !utils.IsOfflineMode() {
+		var desc *v1.Descriptor
+		desc, err = remote.Head(ref, append(options[:len(options):len(options)], remote.WithContext(withManifestAccept(ctx, accept)))...)
+		if err == nil || !isUpstreamUnavailable(err) {
+			return desc, err
+		}
+	}
+
+	var item *utils.CachedItem
+	if utils.IsCacheEnabled() {
+		item, _ = lookupCachedManifest(imageRef, reference, accept)
+	}
+	if item == nil || (!utils.IsOfflineMode() && !item.WithinStaleGrace()) {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s:%s 未缓存: %w", imageRef, reference, errOfflineMode)
+	}
+
+	digest, hashErr := v1.NewHash(item.Headers["Docker-Content-Digest"])
+	if hashErr != nil {
+		return nil, hashErr
+	}
+	return &v1.Descriptor{
+		MediaType: types.MediaType(item.ContentType),
+		Size:      int64(len(item.Data)),
+		Digest:    digest,
+	}, nil
+}
+
+// writeCachedBlob 命中共享的小 blob 缓存（如镜像config）时直接响应
+func writeCachedBlob(c *gin.Context, scope, digest string) bool {
+	if !utils.IsCacheEnabled() {
+		return false
+	}
+
+	cachedItem := utils.GlobalCache.Get(utils.BuildBlobCacheKey(scope, digest))
+	if cachedItem == nil {
+		return false
+	}
+
+	c.Header("Docker-Content-Digest", digest)
+	utils.WriteCachedResponse(c, cachedItem)
+	return true
+}
+
+// errBlobDigestMismatch 上游返回的 blob 内容与请求的 digest 不符
+var errBlobDigestMismatch = errors.New("blob digest 校验失败")
+
+// blobDigestMismatches 累计的 blob digest 校验失败次数
+var blobDigestMismatches atomic.Int64
+
+// BlobDigestMismatches 返回累计的 blob digest 校验失败次数
+func BlobDigestMismatches() int64 {
+	return blobDigestMismatches.Load()
+}
+
+// streamBlob 将上游 blob 写给客户端，体积较小的 blob 同时写入共享缓存。
+// verify 为 true 时边传输边校验 sha256，不符时中断连接，客户端不会收到完整的响应
+func streamBlob(c *gin.Context, scope, digest string, size int64, reader io.Reader, verify bool) {
+	defer utils.BeginTransfer()()
+
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Length", fmt.Sprintf("%d", size))
+	c.Header("Docker-Content-Digest", digest)
+	c.Status(http.StatusOK)
+
+	cacheable := utils.IsCacheEnabled() && size <= utils.MaxCachedBlobSize
+	var buf bytes.Buffer
+	if cacheable {
+		reader = io.TeeReader(reader, &buf)
+	}
+
+	var err error
+	if hash, hashErr := v1.NewHash(digest); verify && hashErr == nil && hash.Algorithm == "sha256" {
+		err = copyVerifiedBlob(c.Writer, reader, hash, size)
+	} else {
+		_, err = io.Copy(c.Writer, reader)
+	}
+
+	if errors.Is(err, errBlobDigestMismatch) {
+		blobDigestMismatches.Add(1)
+		utils.GlobalCache.Delete(utils.BuildBlobCacheKey(scope, digest))
+		fmt.Printf("blob %s 内容与 digest 不符，已中断连接\n", digest)
+		utils.AbortConnection(c)
+		return
+	}
+	if err != nil {
+		fmt.Printf("复制layer内容失败: %v\n", err)
+		return
+	}
+	if cacheable && int64(buf.Len()) == size {
+		utils.GlobalCache.Set(utils.BuildBlobCacheKey(scope, digest), buf.Bytes(), "application/octet-stream", nil, utils.GetManifestTTL(digest))
+	}
+}
+
+// copyVerifiedBlob 边复制边计算 sha256，最后一段数据在校验通过后才写出，
+// 校验失败或读取出错时响应体不完整，连接不会被当作正常结束
+func copyVerifiedBlob(dst io.Writer, src io.Reader, digest v1.Hash, size int64) error {
+	hasher := sha256.New()
+	buf := make([]byte, 32*1024)
+	var pending []byte
+	var read int64
+
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if len(pending) > 0 {
+				if _, werr := dst.Write(pending); werr != nil {
+					return werr
+				}
+			}
+			pending = append(pending[:0], buf[:n]...)
+			hasher.Write(buf[:n])
+			read += int64(n)
+		}
+		if err == nil {
+			continue
+		}
+
+		// 读完整个 blob 后才能判断 digest；go-containerregistry 自身校验失败时同样在此返回错误
+		if err != io.EOF && read != size {
+			return err
+		}
+		if hex.EncodeToString(hasher.Sum(nil)) != digest.Hex {
+			return errBlobDigestMismatch
+		}
+		if err != io.EOF {
+			return err
+		}
+		_, werr := dst.Write(pending)
+		return werr
+	}
+}
+
 // InitDockerProxy 初始化Docker代理
 func InitDockerProxy() {
 	registry, err := name.NewRegistry("registry-1.docker.io")
@@ -77,7 +710,7 @@ func InitDockerProxy() {
 	options := []remote.Option{
 		remote.WithAuth(authn.Anonymous),
 		remote.WithUserAgent("hubproxy/go-containerregistry"),
-		remote.WithTransport(utils.GetGlobalHTTPClient().Transport),
+		remote.WithTransport(&upstreamTransport{base: utils.GetGlobalHTTPClient().Transport}),
 	}
 
 	dockerProxy = &DockerProxy{
@@ -88,6 +721,9 @@ func InitDockerProxy() {
 
 // ProxyDockerRegistryGin 标准Docker Registry API v2代理
 func ProxyDockerRegistryGin(c *gin.Context) {
+	if rejectUnservedHost(c, hostServeRegistry) {
+		return
+	}
 	path := c.Request.URL.Path
 
 	if path == "/v2/" {
@@ -171,22 +807,24 @@ func parseRegistryPath(path string) (imageName, apiType, reference string) {
 
 // handleManifestRequest 处理manifest请求
 func handleManifestRequest(c *gin.Context, imageRef, reference string) {
-	if utils.IsCacheEnabled() && c.Request.Method == http.MethodGet {
-		cacheKey := utils.BuildManifestCacheKey(imageRef, reference)
-
-		if cachedItem := utils.GlobalCache.Get(cacheKey); cachedItem != nil {
-			utils.WriteCachedResponse(c, cachedItem)
+	if c.Request.Method != http.MethodHead {
+		cachedItem, err := fetchManifest(c.Request.Context(), imageRef, reference, parseManifestAccept(c.Request.Header), dockerProxy.options)
+		if err != nil {
+			fmt.Printf("GET请求失败: %v\n", err)
+			c.String(http.StatusNotFound, "Manifest not found")
 			return
 		}
+		writeManifestResponse(c, cachedItem)
+		return
 	}
 
 	var ref name.Reference
 	var err error
 
 	if strings.HasPrefix(reference, "sha256:") {
-		ref, err = name.NewDigest(fmt.Sprintf("%s@%s", imageRef, reference))
+		ref, err = name.NewDigest(fmt.Sprintf("%s@%s", imageRef, reference), upstreamNameOptions(imageRef)...)
 	} else {
-		ref, err = name.NewTag(fmt.Sprintf("%s:%s", imageRef, reference))
+		ref, err = name.NewTag(fmt.Sprintf("%s:%s", imageRef, reference), upstreamNameOptions(imageRef)...)
 	}
 
 	if err != nil {
@@ -195,56 +833,30 @@ func handleManifestRequest(c *gin.Context, imageRef, reference string) {
 		return
 	}
 
-	if c.Request.Method == http.MethodHead {
-		desc, err := remote.Head(ref, dockerProxy.options...)
-		if err != nil {
-			fmt.Printf("HEAD请求失败: %v\n", err)
-			c.String(http.StatusNotFound, "Manifest not found")
-			return
-		}
-
-		c.Header("Content-Type", string(desc.MediaType))
-		c.Header("Docker-Content-Digest", desc.Digest.String())
-		c.Header("Content-Length", fmt.Sprintf("%d", desc.Size))
-		c.Status(http.StatusOK)
-	} else {
-		desc, err := remote.Get(ref, dockerProxy.options...)
-		if err != nil {
-			fmt.Printf("GET请求失败: %v\n", err)
-			c.String(http.StatusNotFound, "Manifest not found")
-			return
-		}
-
-		headers := map[string]string{
-			"Docker-Content-Digest": desc.Digest.String(),
-			"Content-Length":        fmt.Sprintf("%d", len(desc.Manifest)),
-		}
-
-		if utils.IsCacheEnabled() {
-			cacheKey := utils.BuildManifestCacheKey(imageRef, reference)
-			ttl := utils.GetManifestTTL(reference)
-			utils.GlobalCache.Set(cacheKey, desc.Manifest, string(desc.MediaType), headers, ttl)
-		}
-
-		c.Header("Content-Type", string(desc.MediaType))
-		for key, value := range headers {
-			c.Header(key, value)
-		}
-
-		c.Data(http.StatusOK, string(desc.MediaType), desc.Manifest)
+	desc, err := headManifest(c.Request.Context(), ref, imageRef, reference, parseManifestAccept(c.Request.Header), dockerProxy.options)
+	if err != nil {
+		fmt.Printf("HEAD请求失败: %v\n", err)
+		c.String(http.StatusNotFound, "Manifest not found")
+		return
 	}
+
+	writeManifestHead(c, desc)
 }
 
 // handleBlobRequest 处理blob请求
 func handleBlobRequest(c *gin.Context, imageRef, digest string) {
-	digestRef, err := name.NewDigest(fmt.Sprintf("%s@%s", imageRef, digest))
+	if writeCachedBlob(c, dockerHubCacheScope, digest) {
+		return
+	}
+
+	digestRef, err := name.NewDigest(fmt.Sprintf("%s@%s", imageRef, digest), upstreamNameOptions(imageRef)...)
 	if err != nil {
 		fmt.Printf("解析digest引用失败: %v\n", err)
 		c.String(http.StatusBadRequest, "Invalid digest reference")
 		return
 	}
 
-	layer, err := remote.Layer(digestRef, dockerProxy.options...)
+	layer, err := remote.Layer(digestRef, append(dockerProxy.options[:len(dockerProxy.options):len(dockerProxy.options)], remote.WithContext(c.Request.Context()))...)
 	if err != nil {
 		fmt.Printf("获取layer失败: %v\n", err)
 		c.String(http.StatusNotFound, "Layer not found")
@@ -266,19 +878,12 @@ func handleBlobRequest(c *gin.Context, imageRef, digest string) {
 	}
 	defer reader.Close()
 
-	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Length", fmt.Sprintf("%d", size))
-	c.Header("Docker-Content-Digest", digest)
-
-	c.Status(http.StatusOK)
-	if _, err := io.Copy(c.Writer, reader); err != nil {
-		fmt.Printf("复制layer内容失败: %v\n", err)
-	}
+	streamBlob(c, dockerHubCacheScope, digest, size, reader, true)
 }
 
 // handleTagsRequest 处理tags列表请求
 func handleTagsRequest(c *gin.Context, imageRef string) {
-	repo, err := name.NewRepository(imageRef)
+	repo, err := name.NewRepository(imageRef, upstreamNameOptions(imageRef)...)
 	if err != nil {
 		fmt.Printf("解析repository失败: %v\n", err)
 		c.String(http.StatusBadRequest, "Invalid repository")
@@ -302,22 +907,47 @@ func handleTagsRequest(c *gin.Context, imageRef string) {
 
 // ProxyDockerAuthGin Docker认证代理
 func ProxyDockerAuthGin(c *gin.Context) {
-	if utils.IsTokenCacheEnabled() {
+	if rejectUnservedHost(c, hostServeRegistry) {
+		return
+	}
+	if utils.IsTokenCacheEnabled() || utils.IsOfflineMode() {
 		proxyDockerAuthWithCache(c)
 	} else {
 		proxyDockerAuthOriginal(c)
 	}
 }
 
-// proxyDockerAuthWithCache 带缓存的认证代理
+// proxyDockerAuthWithCache 带缓存的认证代理，携带凭据的请求按凭据摘要隔离缓存，避免私有 scope 的 token 被匿名用户复用
 func proxyDockerAuthWithCache(c *gin.Context) {
-	cacheKey := utils.BuildTokenCacheKey(c.Request.URL.RawQuery)
+	credential := utils.CredentialFingerprint(c.GetHeader("Authorization"))
+	if credential != "" && !utils.IsAuthenticatedTokenCacheEnabled() {
+		if utils.IsOfflineMode() {
+			c.String(http.StatusServiceUnavailable, "Token not cached (offline mode)")
+			return
+		}
+		proxyDockerAuthOriginal(c)
+		return
+	}
+
+	cacheQuery := c.Request.URL.RawQuery
+	if domain, ok := hostRegistry(c); ok {
+		cacheQuery = domain + "?" + cacheQuery
+	}
+	if credential != "" {
+		cacheQuery += "#" + credential
+	}
+	cacheKey := utils.BuildTokenCacheKey(cacheQuery)
 
 	if cachedToken := utils.GlobalCache.GetToken(cacheKey); cachedToken != "" {
 		utils.WriteTokenResponse(c, cachedToken)
 		return
 	}
 
+	if utils.IsOfflineMode() {
+		c.String(http.StatusServiceUnavailable, "Token not cached (offline mode)")
+		return
+	}
+
 	recorder := &ResponseRecorder{
 		ResponseWriter: c.Writer,
 		statusCode:     200,
@@ -332,6 +962,8 @@ func proxyDockerAuthWithCache(c *gin.Context) {
 	}
 
 	c.Writer = recorder.ResponseWriter
+
+	// 过期 token 已超出上游 expires_in，认证服务故障时同样不返回
 	c.Data(recorder.statusCode, "application/json", recorder.body)
 }
 
@@ -354,9 +986,20 @@ func (r *ResponseRecorder) Write(data []byte) (int, error) {
 func proxyDockerAuthOriginal(c *gin.Context) {
 	authURL := buildDockerAuthURL(c)
 
+	transport := utils.GetGlobalHTTPClient().Transport
+	if mapping, ok := requestAuthMapping(c); ok {
+		registryTransport, err := utils.RegistryTransport(mapping)
+		if err != nil {
+			fmt.Printf("Registry %s 的 TLS 配置无效: %v\n", mapping.Upstream, err)
+			c.String(http.StatusBadGateway, "Auth request failed")
+			return
+		}
+		transport = registryTransport
+	}
+
 	client := &http.Client{
 		Timeout:   30 * time.Second,
-		Transport: utils.GetGlobalHTTPClient().Transport,
+		Transport: transport,
 	}
 
 	req, err := http.NewRequestWithContext(
@@ -370,34 +1013,26 @@ func proxyDockerAuthOriginal(c *gin.Context) {
 		return
 	}
 
-	for key, values := range c.Request.Header {
-		for _, value := range values {
-			req.Header.Add(key, value)
-		}
-	}
+	utils.CopyEndToEndHeaders(req.Header, c.Request.Header)
 
 	resp, err := client.Do(req)
+	upstreamHealthTracker.observe(req, resp, err)
 	if err != nil {
 		c.String(http.StatusBadGateway, "Auth request failed")
 		return
 	}
 	defer resp.Body.Close()
 
-	proxyHost := c.Request.Host
-	if proxyHost == "" {
-		cfg := config.GetConfig()
-		proxyHost = fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
-		if cfg.Server.Host == "0.0.0.0" {
-			proxyHost = fmt.Sprintf("localhost:%d", cfg.Server.Port)
-		}
-	}
+	publicBase := utils.PublicBaseURL(c)
 
-	for key, values := range resp.Header {
+	respHeader := make(http.Header, len(resp.Header))
+	utils.CopyEndToEndHeaders(respHeader, resp.Header)
+	for key, values := range respHeader {
 		for _, value := range values {
 			if key == "Www-Authenticate" {
-				value = rewriteAuthHeader(value, proxyHost)
+				value = rewriteAuthHeader(value, publicBase)
 			}
-			c.Header(key, value)
+			c.Writer.Header().Add(key, value)
 		}
 	}
 
@@ -410,10 +1045,9 @@ func proxyDockerAuthOriginal(c *gin.Context) {
 // buildDockerAuthURL 根据 token 请求的 service 参数选择上游认证地址。
 // AuthHost 已包含路径（如 ghcr.io/token、quay.io/v2/auth），不再拼接本机 Path，避免 /token/token。
 func buildDockerAuthURL(c *gin.Context) string {
-	authHost := resolveAuthHost(c.Query("service"))
 	var authURL string
-	if authHost != "" {
-		authURL = "https://" + authHost
+	if mapping, ok := requestAuthMapping(c); ok {
+		authURL = registryScheme(mapping) + "://" + mapping.AuthHost
 	} else {
 		authURL = "https://auth.docker.io" + c.Request.URL.Path
 	}
@@ -425,8 +1059,14 @@ func buildDockerAuthURL(c *gin.Context) string {
 
 // resolveAuthHost 用 service 匹配已启用 Registry 的 AuthHost；Docker Hub 返回空串走默认路径。
 func resolveAuthHost(service string) string {
+	mapping, _ := resolveAuthMapping(service)
+	return mapping.AuthHost
+}
+
+// resolveAuthMapping 用 service 匹配配置了 AuthHost 的已启用 Registry，认证请求沿用其 scheme 与 TLS 设置
+func resolveAuthMapping(service string) (config.RegistryMapping, bool) {
 	if service == "" || service == "registry.docker.io" || service == "docker.io" {
-		return ""
+		return config.RegistryMapping{}, false
 	}
 
 	cfg := config.GetConfig()
@@ -435,25 +1075,54 @@ func resolveAuthHost(service string) string {
 			continue
 		}
 		if service == domain || service == mapping.Upstream {
-			return mapping.AuthHost
+			return mapping, true
 		}
 	}
-	return ""
+	return config.RegistryMapping{}, false
+}
+
+// requestAuthMapping 按 service 参数匹配 Registry（透传的域名自动发现认证端点），未匹配时使用当前 Host 绑定的 Registry
+func requestAuthMapping(c *gin.Context) (config.RegistryMapping, bool) {
+	service := c.Query("service")
+	if mapping, ok := resolveAuthMapping(service); ok {
+		return mapping, true
+	}
+	if passthroughAllowed(service) {
+		mapping, err := discoverPassthroughAuth(service)
+		if err == nil {
+			return mapping, true
+		}
+		fmt.Printf("%v\n", err)
+	}
+	if domain, ok := hostRegistry(c); ok {
+		mapping, _ := registryDetector.getRegistryMapping(domain)
+		return mapping, mapping.AuthHost != ""
+	}
+	return config.RegistryMapping{}, false
+}
+
+// registryScheme 返回访问上游使用的协议
+func registryScheme(mapping config.RegistryMapping) string {
+	if strings.EqualFold(mapping.Scheme, "http") {
+		return "http"
+	}
+	return "https"
 }
 
 // rewriteAuthHeader 将上游认证 realm 统一改写到本机 /token，避免 quay 等变成 /v2/auth 误入 Registry 路由。
-func rewriteAuthHeader(authHeader, proxyHost string) string {
-	proxyToken := "http://" + proxyHost + "/token"
+// publicBase 为客户端可见的 scheme://host，HTTPS 反代后不会被降级为 http。
+func rewriteAuthHeader(authHeader, publicBase string) string {
+	proxyToken := publicBase + "/token"
 
 	cfg := config.GetConfig()
 	for _, mapping := range cfg.Registries {
 		if mapping.AuthHost == "" {
 			continue
 		}
-		authHeader = strings.ReplaceAll(authHeader, "https://"+mapping.AuthHost, proxyToken)
+		authHeader = strings.ReplaceAll(authHeader, registryScheme(mapping)+"://"+mapping.AuthHost, proxyToken)
 	}
 	authHeader = strings.ReplaceAll(authHeader, "https://auth.docker.io/token", proxyToken)
-	authHeader = strings.ReplaceAll(authHeader, "https://auth.docker.io", "http://"+proxyHost)
+	authHeader = strings.ReplaceAll(authHeader, "https://auth.docker.io", publicBase)
 
 	return authHeader
 }
@@ -472,22 +1141,27 @@ func handleMultiRegistryRequest(c *gin.Context, registryDomain, remainingPath st
 		return
 	}
 
-	fullImageName := registryDomain + "/" + imageName
+	normalized, upstreamImageRef, err := upstreamRepository(mapping, imageName)
+	if err != nil {
+		fmt.Printf("改写仓库名失败: %v\n", err)
+		c.String(http.StatusBadRequest, "Invalid repository")
+		return
+	}
+
+	fullImageName := registryDomain + "/" + normalized
 	if allowed, reason := utils.GlobalAccessController.CheckDockerAccess(fullImageName); !allowed {
 		fmt.Printf("镜像 %s 访问被拒绝: %s\n", fullImageName, reason)
 		c.String(http.StatusForbidden, "镜像访问被限制")
 		return
 	}
 
-	upstreamImageRef := fmt.Sprintf("%s/%s", mapping.Upstream, imageName)
-
 	switch apiType {
 	case "manifests":
 		handleUpstreamManifestRequest(c, upstreamImageRef, reference, mapping)
 	case "blobs":
 		handleUpstreamBlobRequest(c, upstreamImageRef, reference, mapping)
 	case "tags":
-		handleUpstreamTagsRequest(c, upstreamImageRef, mapping)
+		handleUpstreamTagsRequest(c, upstreamImageRef, imageName, mapping)
 	default:
 		c.String(http.StatusNotFound, "API endpoint not found")
 	}
@@ -495,22 +1169,26 @@ func handleMultiRegistryRequest(c *gin.Context, registryDomain, remainingPath st
 
 // handleUpstreamManifestRequest 处理上游Registry的manifest请求
 func handleUpstreamManifestRequest(c *gin.Context, imageRef, reference string, mapping config.RegistryMapping) {
-	if utils.IsCacheEnabled() && c.Request.Method == http.MethodGet {
-		cacheKey := utils.BuildManifestCacheKey(imageRef, reference)
+	options := createUpstreamOptions(mapping)
 
-		if cachedItem := utils.GlobalCache.Get(cacheKey); cachedItem != nil {
-			utils.WriteCachedResponse(c, cachedItem)
+	if c.Request.Method != http.MethodHead {
+		cachedItem, err := fetchManifest(c.Request.Context(), imageRef, reference, parseManifestAccept(c.Request.Header), options)
+		if err != nil {
+			fmt.Printf("GET请求失败: %v\n", err)
+			c.String(http.StatusNotFound, "Manifest not found")
 			return
 		}
+		writeManifestResponse(c, cachedItem)
+		return
 	}
 
 	var ref name.Reference
 	var err error
 
 	if strings.HasPrefix(reference, "sha256:") {
-		ref, err = name.NewDigest(fmt.Sprintf("%s@%s", imageRef, reference))
+		ref, err = name.NewDigest(fmt.Sprintf("%s@%s", imageRef, reference), upstreamNameOptions(imageRef)...)
 	} else {
-		ref, err = name.NewTag(fmt.Sprintf("%s:%s", imageRef, reference))
+		ref, err = name.NewTag(fmt.Sprintf("%s:%s", imageRef, reference), upstreamNameOptions(imageRef)...)
 	}
 
 	if err != nil {
@@ -519,58 +1197,30 @@ func handleUpstreamManifestRequest(c *gin.Context, imageRef, reference string, m
 		return
 	}
 
-	options := createUpstreamOptions(mapping)
-
-	if c.Request.Method == http.MethodHead {
-		desc, err := remote.Head(ref, options...)
-		if err != nil {
-			fmt.Printf("HEAD请求失败: %v\n", err)
-			c.String(http.StatusNotFound, "Manifest not found")
-			return
-		}
-
-		c.Header("Content-Type", string(desc.MediaType))
-		c.Header("Docker-Content-Digest", desc.Digest.String())
-		c.Header("Content-Length", fmt.Sprintf("%d", desc.Size))
-		c.Status(http.StatusOK)
-	} else {
-		desc, err := remote.Get(ref, options...)
-		if err != nil {
-			fmt.Printf("GET请求失败: %v\n", err)
-			c.String(http.StatusNotFound, "Manifest not found")
-			return
-		}
-
-		headers := map[string]string{
-			"Docker-Content-Digest": desc.Digest.String(),
-			"Content-Length":        fmt.Sprintf("%d", len(desc.Manifest)),
-		}
-
-		if utils.IsCacheEnabled() {
-			cacheKey := utils.BuildManifestCacheKey(imageRef, reference)
-			ttl := utils.GetManifestTTL(reference)
-			utils.GlobalCache.Set(cacheKey, desc.Manifest, string(desc.MediaType), headers, ttl)
-		}
-
-		c.Header("Content-Type", string(desc.MediaType))
-		for key, value := range headers {
-			c.Header(key, value)
-		}
-
-		c.Data(http.StatusOK, string(desc.MediaType), desc.Manifest)
+	desc, err := headManifest(c.Request.Context(), ref, imageRef, reference, parseManifestAccept(c.Request.Header), options)
+	if err != nil {
+		fmt.Printf("HEAD请求失败: %v\n", err)
+		c.String(http.StatusNotFound, "Manifest not found")
+		return
 	}
+
+	writeManifestHead(c, desc)
 }
 
 // handleUpstreamBlobRequest 处理上游Registry的blob请求
 func handleUpstreamBlobRequest(c *gin.Context, imageRef, digest string, mapping config.RegistryMapping) {
-	digestRef, err := name.NewDigest(fmt.Sprintf("%s@%s", imageRef, digest))
+	if writeCachedBlob(c, blobCacheScope(mapping), digest) {
+		return
+	}
+
+	digestRef, err := name.NewDigest(fmt.Sprintf("%s@%s", imageRef, digest), upstreamNameOptions(imageRef)...)
 	if err != nil {
 		fmt.Printf("解析digest引用失败: %v\n", err)
 		c.String(http.StatusBadRequest, "Invalid digest reference")
 		return
 	}
 
-	options := createUpstreamOptions(mapping)
+	options := append(createUpstreamOptions(mapping), remote.WithContext(c.Request.Context()))
 	layer, err := remote.Layer(digestRef, options...)
 	if err != nil {
 		fmt.Printf("获取layer失败: %v\n", err)
@@ -593,19 +1243,12 @@ func handleUpstreamBlobRequest(c *gin.Context, imageRef, digest string, mapping
 	}
 	defer reader.Close()
 
-	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Length", fmt.Sprintf("%d", size))
-	c.Header("Docker-Content-Digest", digest)
-
-	c.Status(http.StatusOK)
-	if _, err := io.Copy(c.Writer, reader); err != nil {
-		fmt.Printf("复制layer内容失败: %v\n", err)
-	}
+	streamBlob(c, blobCacheScope(mapping), digest, size, reader, !mapping.SkipDigestVerify)
 }
 
 // handleUpstreamTagsRequest 处理上游Registry的tags请求
-func handleUpstreamTagsRequest(c *gin.Context, imageRef string, mapping config.RegistryMapping) {
-	repo, err := name.NewRepository(imageRef)
+func handleUpstreamTagsRequest(c *gin.Context, imageRef, repoName string, mapping config.RegistryMapping) {
+	repo, err := name.NewRepository(imageRef, upstreamNameOptions(imageRef)...)
 	if err != nil {
 		fmt.Printf("解析repository失败: %v\n", err)
 		c.String(http.StatusBadRequest, "Invalid repository")
@@ -621,19 +1264,47 @@ func handleUpstreamTagsRequest(c *gin.Context, imageRef string, mapping config.R
 	}
 
 	response := map[string]interface{}{
-		"name": strings.TrimPrefix(imageRef, mapping.Upstream+"/"),
+		"name": repoName,
 		"tags": tags,
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
-// createUpstreamOptions 创建上游Registry选项
+// dockerHubCacheScope Docker Hub 匿名拉取的 blob 缓存范围
+const dockerHubCacheScope = "docker.io"
+
+// useMappingCredentials 映射配置的账号只在 public = true 时使用：HubProxy 不校验客户端身份，
+// 启用后任何能访问本服务的客户端都能经该映射拉取凭据可见的私有镜像
+func useMappingCredentials(mapping config.RegistryMapping) bool {
+	return mapping.Username != "" && mapping.Public
+}
+
+// blobCacheScope 映射的 blob 缓存范围：上游地址，使用凭据时再加上凭据摘要
+func blobCacheScope(mapping config.RegistryMapping) string {
+	if useMappingCredentials(mapping) {
+		return mapping.Upstream + "#" + utils.CredentialFingerprint(mapping.Username+":"+mapping.Password)
+	}
+	return mapping.Upstream
+}
+
+// createUpstreamOptions 创建上游Registry选项，映射允许公开使用账号时以该凭据拉取，否则匿名拉取
 func createUpstreamOptions(mapping config.RegistryMapping) []remote.Option {
+	var auth authn.Authenticator = authn.Anonymous
+	if useMappingCredentials(mapping) {
+		auth = &authn.Basic{Username: mapping.Username, Password: mapping.Password}
+	}
+
+	base, err := utils.RegistryTransport(mapping)
+	if err != nil {
+		fmt.Printf("Registry %s 的 TLS 配置无效: %v\n", mapping.Upstream, err)
+		base = failingTransport{err: err}
+	}
+
 	options := []remote.Option{
-		remote.WithAuth(authn.Anonymous),
+		remote.WithAuth(auth),
 		remote.WithUserAgent("hubproxy/go-containerregistry"),
-		remote.WithTransport(utils.GetGlobalHTTPClient().Transport),
+		remote.WithTransport(&upstreamTransport{base: base}),
 	}
 
 	// 预留将来不同Registry的差异化认证逻辑扩展点
@@ -645,3 +1316,12 @@ func createUpstreamOptions(mapping config.RegistryMapping) []remote.Option {
 
 	return options
 }
+
+// failingTransport TLS 配置无法加载时让请求直接失败，而不是退回系统默认的证书设置
+type failingTransport struct {
+	err error
+}
+
+func (t failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, t.err
+}
@@ -1,14 +1,28 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"hubproxy/config"
+	"hubproxy/utils"
 )
 
 func TestParseRegistryPath(t *testing.T) {
@@ -135,21 +149,519 @@ enabled = true
 		t.Fatal(err)
 	}
 
-	got := rewriteAuthHeader(`Bearer realm="https://quay.io/v2/auth",service="quay.io"`, "proxy.example.com")
+	got := rewriteAuthHeader(`Bearer realm="https://quay.io/v2/auth",service="quay.io"`, "http://proxy.example.com")
 	want := `Bearer realm="http://proxy.example.com/token",service="quay.io"`
 	if got != want {
 		t.Fatalf("quay rewrite: got %q want %q", got, want)
 	}
 
-	got = rewriteAuthHeader(`Bearer realm="https://ghcr.io/token",service="ghcr.io"`, "proxy.example.com")
+	got = rewriteAuthHeader(`Bearer realm="https://ghcr.io/token",service="ghcr.io"`, "http://proxy.example.com")
 	want = `Bearer realm="http://proxy.example.com/token",service="ghcr.io"`
 	if got != want {
 		t.Fatalf("ghcr rewrite: got %q want %q", got, want)
 	}
 
-	got = rewriteAuthHeader(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`, "proxy.example.com")
+	got = rewriteAuthHeader(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`, "http://proxy.example.com")
 	want = `Bearer realm="http://proxy.example.com/token",service="registry.docker.io"`
 	if got != want {
 		t.Fatalf("docker hub rewrite: got %q want %q", got, want)
 	}
+
+	got = rewriteAuthHeader(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`, "https://proxy.example.com")
+	want = `Bearer realm="https://proxy.example.com/token",service="registry.docker.io"`
+	if got != want {
+		t.Fatalf("https rewrite: got %q want %q", got, want)
+	}
+}
+
+func TestManifestCacheNegotiatesAccept(t *testing.T) {
+	loadTestConfig(t, "")
+	gin.SetMode(gin.TestMode)
+
+	listBody := `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.list.v2+json","manifests":[]}`
+	imageBody := `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json","layers":[]}`
+	var hits atomic.Int32
+	var lastAccept atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/manifests/") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		hits.Add(1)
+		accept := r.Header.Get("Accept")
+		lastAccept.Store(accept)
+		if strings.Contains(accept, string(types.DockerManifestList)) {
+			w.Header().Set("Content-Type", string(types.DockerManifestList))
+			w.Write([]byte(listBody))
+			return
+		}
+		w.Header().Set("Content-Type", string(types.DockerManifestSchema2))
+		w.Write([]byte(imageBody))
+	}))
+	defer upstream.Close()
+
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	mapping := config.RegistryMapping{Upstream: host, Enabled: true}
+	get := func(accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/v2/team/app/manifests/v1", nil)
+		c.Request.Header.Set("Accept", accept)
+		handleUpstreamManifestRequest(c, host+"/team/app", "v1", mapping)
+		return w
+	}
+
+	modern := string(types.DockerManifestList) + ", " + string(types.DockerManifestSchema2)
+	legacy := string(types.DockerManifestSchema2)
+
+	if w := get(modern); w.Body.String() != listBody {
+		t.Fatalf("modern client got %q", w.Body.String())
+	}
+	if got := lastAccept.Load(); got != modern {
+		t.Fatalf("upstream Accept = %q", got)
+	}
+	if w := get(legacy); w.Body.String() != imageBody || w.Header().Get("Content-Type") != legacy {
+		t.Fatalf("legacy client got %q", w.Body.String())
+	}
+	if hits.Load() != 2 {
+		t.Fatalf("upstream hits = %d, want 2", hits.Load())
+	}
+
+	if w := get(modern); w.Body.String() != listBody {
+		t.Fatalf("cached modern client got %q", w.Body.String())
+	}
+	if w := get(legacy); w.Body.String() != imageBody {
+		t.Fatalf("cached legacy client got %q", w.Body.String())
+	}
+	if hits.Load() != 2 {
+		t.Fatalf("cache not used, upstream hits = %d", hits.Load())
+	}
+}
+
+func TestManifestConditionalRequests(t *testing.T) {
+	loadTestConfig(t, "")
+	gin.SetMode(gin.TestMode)
+
+	body := `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json","layers":[]}`
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(body)))
+	var hits, notModified atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/manifests/") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		hits.Add(1)
+		w.Header().Set("Docker-Content-Digest", digest)
+		if r.Header.Get("If-None-Match") == `"`+digest+`"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", string(types.DockerManifestSchema2))
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		if r.Method != http.MethodHead {
+			w.Write([]byte(body))
+		}
+	}))
+	defer upstream.Close()
+
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	imageRef := host + "/team/app"
+	mapping := config.RegistryMapping{Upstream: host, Enabled: true}
+	request := func(method, ifNoneMatch string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(method, "/v2/team/app/manifests/v1", nil)
+		if ifNoneMatch != "" {
+			c.Request.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		handleUpstreamManifestRequest(c, imageRef, "v1", mapping)
+		return c
+	}
+
+	if c := request(http.MethodGet, ""); c.Writer.Status() != http.StatusOK || c.Writer.Header().Get("ETag") != `"`+digest+`"` {
+		t.Fatalf("first GET status = %d, etag = %q", c.Writer.Status(), c.Writer.Header().Get("ETag"))
+	}
+	if c := request(http.MethodGet, `W/"`+digest+`"`); c.Writer.Status() != http.StatusNotModified || c.Writer.Size() > 0 {
+		t.Fatalf("conditional GET status = %d", c.Writer.Status())
+	}
+	if c := request(http.MethodHead, `"`+digest+`"`); c.Writer.Status() != http.StatusNotModified {
+		t.Fatalf("conditional HEAD status = %d", c.Writer.Status())
+	}
+	if hits.Load() != 2 {
+		t.Fatalf("upstream hits = %d, want 2", hits.Load())
+	}
+
+	// 让 tag 索引过期，下一次请求应向上游条件请求并复用缓存内容
+	tagKey := utils.BuildManifestTagCacheKey(imageRef, "v1")
+	item, _ := utils.GlobalCache.GetWithStale(tagKey)
+	utils.GlobalCache.Set(tagKey, item.Data, item.ContentType, item.Headers, -time.Second)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/v2/team/app/manifests/v1", nil)
+	handleUpstreamManifestRequest(c, imageRef, "v1", mapping)
+	if w.Body.String() != body || notModified.Load() != 1 {
+		t.Fatalf("revalidated body = %q, upstream 304s = %d", w.Body.String(), notModified.Load())
+	}
+	if _, fresh := utils.GlobalCache.GetWithStale(tagKey); !fresh {
+		t.Fatal("tag index not refreshed after 304")
+	}
+}
+
+func TestManifestServeStaleAndOffline(t *testing.T) {
+	loadTestConfig(t, "")
+	gin.SetMode(gin.TestMode)
+
+	body := `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json","layers":[]}`
+	var down atomic.Bool
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if down.Load() {
+			// 501 不在 ggcr 的重试列表中，避免测试等待退避
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		if !strings.Contains(r.URL.Path, "/manifests/") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Content-Type", string(types.DockerManifestSchema2))
+		w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	imageRef := host + "/team/app"
+	mapping := config.RegistryMapping{Upstream: host, Enabled: true}
+	request := func(method, reference string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(method, "/v2/team/app/manifests/"+reference, nil)
+		handleUpstreamManifestRequest(c, imageRef, reference, mapping)
+		c.Writer.WriteHeaderNow()
+		return w
+	}
+	expireTag := func() {
+		tagKey := utils.BuildManifestTagCacheKey(imageRef, "v1")
+		item, _ := utils.GlobalCache.GetWithStale(tagKey)
+		utils.GlobalCache.Set(tagKey, item.Data, item.ContentType, item.Headers, -time.Minute)
+	}
+
+	if w := request(http.MethodGet, "v1"); w.Code != http.StatusOK {
+		t.Fatalf("initial GET status = %d", w.Code)
+	}
+
+	down.Store(true)
+	expireTag()
+	if w := request(http.MethodGet, "v1"); w.Code != http.StatusOK || w.Body.String() != body {
+		t.Fatalf("stale GET status = %d, body = %q", w.Code, w.Body.String())
+	}
+	if w := request(http.MethodHead, "v1"); w.Code != http.StatusOK || w.Header().Get("Docker-Content-Digest") == "" {
+		t.Fatalf("stale HEAD status = %d", w.Code)
+	}
+
+	loadTestConfig(t, `
+[cache]
+staleGracePeriod = "30s"
+`)
+	expireTag()
+	if w := request(http.MethodGet, "v1"); w.Code != http.StatusNotFound {
+		t.Fatalf("GET beyond grace status = %d, want 404", w.Code)
+	}
+
+	loadTestConfig(t, `
+[cache]
+offline = true
+`)
+	hits.Store(0)
+	if w := request(http.MethodGet, "v1"); w.Code != http.StatusOK || w.Body.String() != body {
+		t.Fatalf("offline GET status = %d, body = %q", w.Code, w.Body.String())
+	}
+	if w := request(http.MethodHead, "v1"); w.Code != http.StatusOK {
+		t.Fatalf("offline HEAD status = %d", w.Code)
+	}
+	if w := request(http.MethodGet, "v2"); w.Code != http.StatusNotFound {
+		t.Fatalf("offline uncached GET status = %d, want 404", w.Code)
+	}
+	if hits.Load() != 0 {
+		t.Fatalf("offline mode contacted upstream %d times", hits.Load())
+	}
+}
+
+func TestPlainHTTPRegistry(t *testing.T) {
+	loadTestConfig(t, `
+[registries."harbor.internal"]
+upstream = "harbor.internal"
+authHost = "harbor.internal/service/token"
+enabled = true
+scheme = "http"
+`)
+
+	repo, err := name.NewRepository("harbor.internal/team/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := resolveUpstreamTarget(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := target.repository.Scheme(); got != "http" {
+		t.Fatalf("repository scheme = %q", got)
+	}
+	if got := upstreamNameOptions("ghcr.io/team/app"); len(got) != 0 {
+		t.Fatalf("https registry got name options %v", got)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/token?service=harbor.internal&scope=repository:team/app:pull", nil)
+	if got, want := buildDockerAuthURL(c), "http://harbor.internal/service/token?service=harbor.internal&scope=repository:team/app:pull"; got != want {
+		t.Fatalf("auth url = %q, want %q", got, want)
+	}
+}
+
+func TestRegistryAliases(t *testing.T) {
+	loadTestConfig(t, `
+[registries."registry.k8s.io"]
+upstream = "registry.k8s.io"
+enabled = true
+aliases = ["k8s"]
+`)
+	gin.SetMode(gin.TestMode)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/v2/k8s/kube-apiserver/manifests/v1.30.0", nil)
+	if domain, rest := registryDetector.detectRegistryDomain(c, "k8s/kube-apiserver/manifests/v1.30.0"); domain != "registry.k8s.io" || rest != "kube-apiserver/manifests/v1.30.0" {
+		t.Fatalf("path alias = (%q, %q)", domain, rest)
+	}
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/v2/kube-apiserver/manifests/v1.30.0?ns=k8s", nil)
+	if domain, _ := registryDetector.detectRegistryDomain(c, "kube-apiserver/manifests/v1.30.0"); domain != "registry.k8s.io" {
+		t.Fatalf("ns alias = %q", domain)
+	}
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/image/info?image=k8s/pause:3.9", nil)
+	if got := resolveImageRef(c); got != "registry.k8s.io/pause:3.9" {
+		t.Fatalf("api image ref = %q", got)
+	}
+	if got := expandRegistryAlias("k8sio/app:v1"); got != "k8sio/app:v1" {
+		t.Fatalf("non-alias namespace expanded to %q", got)
+	}
+
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/k8s/") {
+			w.Write([]byte(`{"count":3}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer hub.Close()
+	original := dockerHubNamespaceURL
+	dockerHubNamespaceURL = hub.URL + "/v2/repositories/%s/?page_size=1"
+	defer func() { dockerHubNamespaceURL = original }()
+
+	if exists, err := dockerHubNamespaceExists("k8s"); err != nil || !exists {
+		t.Fatalf("k8s namespace: exists=%v err=%v", exists, err)
+	}
+	if exists, err := dockerHubNamespaceExists("gh"); err != nil || exists {
+		t.Fatalf("gh namespace: exists=%v err=%v", exists, err)
+	}
+}
+
+func TestTokenCacheIsolatesCredentials(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var hits atomic.Int32
+	var upgradeForwarded atomic.Bool
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.Header.Get("Upgrade") != "" || r.Header.Get("X-Hop") != "" {
+			upgradeForwarded.Store(true)
+		}
+		token := "anonymous"
+		if r.Header.Get("Authorization") != "" {
+			token = "private-" + r.Header.Get("Authorization")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Connection", "close")
+		fmt.Fprintf(w, `{"token":%q,"expires_in":300}`, token)
+	}))
+	defer authServer.Close()
+	host := strings.TrimPrefix(authServer.URL, "http://")
+
+	fetch := func(scope, authorization string) string {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/token?service=private.test&scope=repository:"+scope+":pull", nil)
+		c.Request.Header.Set("Connection", "Upgrade, X-Hop")
+		c.Request.Header.Set("Upgrade", "h2c")
+		c.Request.Header.Set("X-Hop", "1")
+		if authorization != "" {
+			c.Request.Header.Set("Authorization", authorization)
+		}
+		ProxyDockerAuthGin(c)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d; body=%s", w.Code, w.Body.String())
+		}
+		if w.Header().Get("Connection") != "" {
+			t.Fatal("hop-by-hop response header copied to client")
+		}
+		return w.Body.String()
+	}
+
+	for _, cacheAuthenticated := range []bool{true, false} {
+		loadTestConfig(t, fmt.Sprintf(`
+[tokenCache]
+enabled = true
+cacheAuthenticated = %t
+
+[registries."private.test"]
+upstream = %q
+authHost = "%s/token"
+scheme = "http"
+enabled = true
+`, cacheAuthenticated, host, host))
+		hits.Store(0)
+		scope := fmt.Sprintf("team/secret-%t", cacheAuthenticated)
+
+		if got := fetch(scope, "Basic YWxpY2U6cHc="); !strings.Contains(got, "private-") {
+			t.Fatalf("authenticated token = %s", got)
+		}
+		if got := fetch(scope, ""); !strings.Contains(got, "anonymous") {
+			t.Fatalf("anonymous user got %s", got)
+		}
+		fetch(scope, "Basic YWxpY2U6cHc=")
+		fetch(scope, "")
+
+		want := int32(2)
+		if !cacheAuthenticated {
+			want = 3
+		}
+		if got := hits.Load(); got != want {
+			t.Fatalf("cacheAuthenticated=%t: upstream hits = %d, want %d", cacheAuthenticated, got, want)
+		}
+	}
+	if upgradeForwarded.Load() {
+		t.Fatal("hop-by-hop request headers forwarded upstream")
+	}
+}
+
+func TestExpiredTokenNeverServedStale(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var down atomic.Bool
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"token":"fresh","expires_in":300}`)
+	}))
+	defer authServer.Close()
+	host := strings.TrimPrefix(authServer.URL, "http://")
+
+	registryConfig := fmt.Sprintf(`
+[registries."stale-token.test"]
+upstream = %q
+authHost = "%s/token"
+scheme = "http"
+enabled = true
+`, host, host)
+	loadTestConfig(t, `
+[tokenCache]
+enabled = true
+
+[cache]
+staleGracePeriod = "24h"
+`+registryConfig)
+
+	query := "service=stale-token.test&scope=repository:team/app:pull"
+	fetch := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/token?"+query, nil)
+		ProxyDockerAuthGin(c)
+		return w
+	}
+
+	if w := fetch(); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "fresh") {
+		t.Fatalf("initial token: %d %s", w.Code, w.Body.String())
+	}
+	cacheKey := utils.BuildTokenCacheKey(query)
+
+	down.Store(true)
+	utils.GlobalCache.Expire(cacheKey)
+	if w := fetch(); w.Code != http.StatusBadGateway {
+		t.Fatalf("expired token served during upstream outage: %d %s", w.Code, w.Body.String())
+	}
+
+	down.Store(false)
+	fetch()
+	loadTestConfig(t, `
+[tokenCache]
+enabled = true
+
+[cache]
+offline = true
+`+registryConfig)
+	utils.GlobalCache.Expire(cacheKey)
+	if w := fetch(); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expired token served in offline mode: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestMappingCredentialsRequirePublic(t *testing.T) {
+	backend := registry.New()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "robot" || pass != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="private"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+	host := strings.TrimPrefix(upstream.URL, "http://")
+
+	img, err := random.Image(256, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference(host + "/team/private:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, img, remote.WithAuth(&authn.Basic{Username: "robot", Password: "secret"})); err != nil {
+		t.Fatal(err)
+	}
+
+	mappingConfig := func(public bool) string {
+		return fmt.Sprintf(`
+[registries."private.test"]
+upstream = %q
+username = "robot"
+password = "secret"
+public = %t
+enabled = true
+`, host, public)
+	}
+
+	loadTestConfig(t, mappingConfig(false))
+	if _, err := resolveImageSource(context.Background(), "private.test/team/private:v1"); err == nil {
+		t.Fatal("credentials used without public = true")
+	}
+
+	loadTestConfig(t, mappingConfig(true))
+	if _, err := resolveImageSource(context.Background(), "private.test/team/private:v1"); err != nil {
+		t.Fatalf("public mapping: %v", err)
+	}
+
+	anonymous := config.RegistryMapping{Upstream: host}
+	credentialed := config.RegistryMapping{Upstream: host, Username: "robot", Password: "secret", Public: true}
+	other := config.RegistryMapping{Upstream: "other.test"}
+	scopes := map[string]bool{blobCacheScope(anonymous): true, blobCacheScope(credentialed): true, blobCacheScope(other): true}
+	if len(scopes) != 3 {
+		t.Fatalf("blob cache scopes are shared: %v", scopes)
+	}
 }
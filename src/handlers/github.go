@@ -38,6 +38,9 @@ var blockedContentTypes = map[string]bool{
 
 // GitHubProxyHandler GitHub代理处理器
 func GitHubProxyHandler(c *gin.Context) {
+	if rejectUnservedHost(c, hostServeGitHub) {
+		return
+	}
 	rawPath := strings.TrimPrefix(c.Request.URL.RequestURI(), "/")
 
 	for strings.HasPrefix(rawPath, "/") {
@@ -93,6 +96,7 @@ func CheckGitHubURL(u string) []string {
 
 // ProxyGitHubRequest 代理GitHub请求
 func ProxyGitHubRequest(c *gin.Context, u string) {
+	defer utils.BeginTransfer()()
 	proxyGitHubWithRedirect(c, u, 0)
 }
 
@@ -104,7 +108,7 @@ func proxyGitHubWithRedirect(c *gin.Context, u string, redirectCount int) {
 		return
 	}
 
-	req, err := http.NewRequest(c.Request.Method, u, c.Request.Body)
+	req, err := http.NewRequestWithContext(c.Request.Context(), c.Request.Method, u, c.Request.Body)
 	if err != nil {
 		c.String(http.StatusInternalServerError, fmt.Sprintf("server error %v", err))
 		return
@@ -156,13 +160,7 @@ func proxyGitHubWithRedirect(c *gin.Context, u string, redirectCount int) {
 	resp.Header.Del("Strict-Transport-Security")
 
 	// 获取真实域名
-	realHost := c.Request.Header.Get("X-Forwarded-Host")
-	if realHost == "" {
-		realHost = c.Request.Host
-	}
-	if !strings.HasPrefix(realHost, "http://") && !strings.HasPrefix(realHost, "https://") {
-		realHost = "https://" + realHost
-	}
+	realHost := utils.PublicBaseURL(c)
 
 	// 处理.sh和.ps1文件的智能处理
 	if strings.HasSuffix(strings.ToLower(u), ".sh") || strings.HasSuffix(strings.ToLower(u), ".ps1") {
@@ -192,7 +190,7 @@ func proxyGitHubWithRedirect(c *gin.Context, u string, redirectCount int) {
 		// 处理重定向
 		if location := resp.Header.Get("Location"); location != "" {
 			if CheckGitHubURL(location) != nil {
-				c.Header("Location", "/"+location)
+				c.Header("Location", config.GetConfig().Server.BasePath+"/"+location)
 			} else {
 				proxyGitHubWithRedirect(c, location, redirectCount+1)
 				return
@@ -216,7 +214,7 @@ func proxyGitHubWithRedirect(c *gin.Context, u string, redirectCount int) {
 		// 处理重定向
 		if location := resp.Header.Get("Location"); location != "" {
 			if CheckGitHubURL(location) != nil {
-				c.Header("Location", "/"+location)
+				c.Header("Location", config.GetConfig().Server.BasePath+"/"+location)
 			} else {
 				proxyGitHubWithRedirect(c, location, redirectCount+1)
 				return
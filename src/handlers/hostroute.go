@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"hubproxy/config"
+)
+
+// [hosts] 中 serve 的取值
+const (
+	hostServeAll      = "all"
+	hostServeRegistry = "registry"
+	hostServeGitHub   = "github"
+)
+
+// lookupHostRoute 按请求的 Host（忽略端口与大小写）查找 [hosts] 配置
+func lookupHostRoute(c *gin.Context) (config.HostRoute, bool) {
+	host := c.Request.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for name, route := range config.GetConfig().Hosts {
+		if strings.EqualFold(name, host) {
+			return route, true
+		}
+	}
+	return config.HostRoute{}, false
+}
+
+// hostServes 判断当前 Host 是否承载指定类型的流量，未配置的 Host 承载全部流量
+func hostServes(c *gin.Context, traffic string) bool {
+	route, ok := lookupHostRoute(c)
+	if !ok || route.Serve == "" || route.Serve == hostServeAll {
+		return true
+	}
+	return route.Serve == traffic
+}
+
+// hostRegistry 返回当前 Host 绑定的已启用 Registry 域名，绑定 docker.io 或未绑定时按路径识别
+func hostRegistry(c *gin.Context) (string, bool) {
+	route, ok := lookupHostRoute(c)
+	if !ok || route.Registry == "" || !registryDetector.isRegistryEnabled(route.Registry) {
+		return "", false
+	}
+	return route.Registry, true
+}
+
+// rejectUnservedHost 当前 Host 不承载该类流量时返回 404
+func rejectUnservedHost(c *gin.Context, traffic string) bool {
+	if hostServes(c, traffic) {
+		return false
+	}
+	c.String(http.StatusNotFound, "Not found")
+	return true
+}
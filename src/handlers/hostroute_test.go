@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHostRouting(t *testing.T) {
+	loadTestConfig(t, `
+[registries."ghcr.io"]
+upstream = "ghcr.io"
+authHost = "ghcr.io/token"
+enabled = true
+
+[hosts."ghcr.example.com"]
+registry = "ghcr.io"
+serve = "registry"
+
+[hosts."gh.example.com"]
+serve = "github"
+`)
+	gin.SetMode(gin.TestMode)
+
+	newContext := func(host, target string) (*gin.Context, *httptest.ResponseRecorder) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+		c.Request.Host = host
+		return c, w
+	}
+
+	c, _ := newContext("GHCR.example.com:443", "/v2/org/img/manifests/latest")
+	if domain, rest := registryDetector.detectRegistryDomain(c, "org/img/manifests/latest"); domain != "ghcr.io" || rest != "org/img/manifests/latest" {
+		t.Fatalf("host routing = (%q, %q)", domain, rest)
+	}
+	c, _ = newContext("proxy.example.com", "/v2/org/img/manifests/latest")
+	if domain, _ := registryDetector.detectRegistryDomain(c, "org/img/manifests/latest"); domain != "" {
+		t.Fatalf("unmapped host routed to %q", domain)
+	}
+
+	c, _ = newContext("ghcr.example.com", "/token?scope=repository:org/img:pull")
+	if got, want := buildDockerAuthURL(c), "https://ghcr.io/token?scope=repository:org/img:pull"; got != want {
+		t.Fatalf("auth url = %q, want %q", got, want)
+	}
+
+	c, w := newContext("ghcr.example.com", "/https://github.com/org/repo/releases/download/v1/a.tar.gz")
+	GitHubProxyHandler(c)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("registry-only host served github traffic: %d", w.Code)
+	}
+	c, w = newContext("gh.example.com", "/v2/")
+	ProxyDockerRegistryGin(c)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("github-only host served registry traffic: %d", w.Code)
+	}
+	c, w = newContext("proxy.example.com", "/v2/")
+	ProxyDockerRegistryGin(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unmapped host: %d", w.Code)
+	}
+}
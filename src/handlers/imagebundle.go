@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-yaml"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// maxBundleManifestSize compose / Kubernetes 清单的最大长度
+const maxBundleManifestSize = 2 << 20
+
+// podContainerKeys Pod 模板中包含镜像的容器列表字段
+var podContainerKeys = []string{"initContainers", "containers", "ephemeralContainers"}
+
+// DeniedImage 被访问控制拒绝的镜像
+type DeniedImage struct {
+	Image  string `json:"image"`
+	Reason string `json:"reason"`
+}
+
+// ExtractImageRefs 从 docker-compose 文件或多文档 Kubernetes YAML 中提取去重后的镜像引用
+func ExtractImageRefs(data []byte) ([]string, error) {
+	var refs []string
+	seen := make(map[string]bool)
+	add := func(ref string) {
+		ref = strings.TrimSpace(ref)
+		if ref == "" || seen[ref] {
+			return
+		}
+		seen[ref] = true
+		refs = append(refs, ref)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc interface{}
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("解析YAML失败: %w", err)
+		}
+
+		root := asStringMap(doc)
+		if root == nil {
+			continue
+		}
+		// docker-compose: services.<name>.image
+		if services := asStringMap(root["services"]); services != nil && root["kind"] == nil {
+			for _, key := range sortedKeys(services) {
+				if image, ok := asStringMap(services[key])["image"].(string); ok {
+					add(expandComposeVariables(image))
+				}
+			}
+			continue
+		}
+		collectPodImages(root, add)
+	}
+
+	return refs, nil
+}
+
+// collectPodImages 递归查找 containers / initContainers 等字段，覆盖 Pod、工作负载、CronJob 及 List
+func collectPodImages(node interface{}, add func(string)) {
+	switch v := node.(type) {
+	case []interface{}:
+		for _, item := range v {
+			collectPodImages(item, add)
+		}
+	default:
+		m := asStringMap(v)
+		if m == nil {
+			return
+		}
+		for _, key := range podContainerKeys {
+			containers, ok := m[key].([]interface{})
+			if !ok {
+				continue
+			}
+			for _, container := range containers {
+				if image, ok := asStringMap(container)["image"].(string); ok {
+					add(image)
+				}
+			}
+		}
+		for _, key := range sortedKeys(m) {
+			if isPodContainerKey(key) {
+				continue
+			}
+			collectPodImages(m[key], add)
+		}
+	}
+}
+
+func isPodContainerKey(key string) bool {
+	for _, k := range podContainerKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// asStringMap 将 YAML 映射统一转换为 map[string]interface{}
+func asStringMap(node interface{}) map[string]interface{} {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = value
+		}
+		return m
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// expandComposeVariables 展开 compose 中带默认值的变量，如 ${TAG:-1.0}；无默认值的变量保持原样
+func expandComposeVariables(value string) string {
+	if !strings.Contains(value, "$") {
+		return value
+	}
+	return os.Expand(value, func(key string) string {
+		for _, sep := range []string{":-", "-"} {
+			if idx := strings.Index(key, sep); idx > 0 {
+				return key[idx+len(sep):]
+			}
+		}
+		return "${" + key + "}"
+	})
+}
+
+// normalizeImageRef 展开 Registry 别名并补全默认的 latest tag，
+// 同时返回规范化的完整引用作为去重键，nginx、nginx:latest 与 docker.io/library/nginx 视为同一镜像
+func normalizeImageRef(image string) (ref, key string, err error) {
+	ref = expandRegistryAlias(strings.TrimSpace(image))
+	parsed, err := name.ParseReference(ref)
+	if err != nil {
+		return ref, ref, err
+	}
+	if tag, ok := parsed.(name.Tag); ok && !strings.HasSuffix(ref, ":"+tag.TagStr()) {
+		ref += ":" + tag.TagStr()
+	}
+	return ref, parsed.Name(), nil
+}
+
+// normalizeBundleImages 展开别名、补全默认 tag 并去重，再按格式与访问控制分类
+func normalizeBundleImages(refs []string) (allowed []string, denied []DeniedImage, invalid []string) {
+	seen := make(map[string]bool)
+	for _, image := range refs {
+		ref, key, err := normalizeImageRef(image)
+		if err != nil {
+			invalid = append(invalid, ref)
+			continue
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if ok, reason := checkImageAccess(ref); !ok {
+			denied = append(denied, DeniedImage{Image: ref, Reason: reason})
+			continue
+		}
+		allowed = append(allowed, ref)
+	}
+	return allowed, denied, invalid
+}
+
+// handleImageExtract 从 compose / Kubernetes 清单提取镜像列表，mode=prepare 时直接准备批量下载
+func handleImageExtract(c *gin.Context) {
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBundleManifestSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取清单失败: " + err.Error()})
+		return
+	}
+
+	refs, err := ExtractImageRefs(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	allowed, denied, invalid := normalizeBundleImages(refs)
+	result := gin.H{
+		"images":  allowed,
+		"denied":  denied,
+		"invalid": invalid,
+		"count":   len(allowed),
+	}
+
+	if c.Query("mode") != "prepare" {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
+	if len(allowed) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "清单中没有可下载的镜像", "denied": denied, "invalid": invalid})
+		return
+	}
+
+	compression, err := parseCompression(c.Query("compression"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	downloadURL, status, errBody := prepareBatchDownload(c, BatchDownloadRequest{
+		Images:              allowed,
+		Platform:            c.Query("platform"),
+		UseCompressedLayers: c.DefaultQuery("compressed", "true") == "true",
+		Compression:         compression,
+		RetagPrefix:         strings.TrimSpace(c.Query("retagPrefix")),
+		SkipFailed:          c.Query("skipFailed") == "true",
+	})
+	if errBody != nil {
+		c.JSON(status, errBody)
+		return
+	}
+	result["download_url"] = downloadURL
+	c.JSON(http.StatusOK, result)
+}
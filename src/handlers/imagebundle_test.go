@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestExtractImageRefsCompose(t *testing.T) {
+	compose := `
+services:
+  web:
+    image: nginx:1.27
+  worker:
+    image: ghcr.io/org/worker:${TAG:-v2}
+  cache:
+    image: redis
+  build-only:
+    build: .
+  web2:
+    image: nginx:1.27
+`
+	refs, err := ExtractImageRefs([]byte(compose))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"redis", "nginx:1.27", "ghcr.io/org/worker:v2"}
+	if !reflect.DeepEqual(refs, want) {
+		t.Fatalf("refs = %v, want %v", refs, want)
+	}
+}
+
+func TestExtractImageRefsKubernetes(t *testing.T) {
+	manifests := `
+apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: init
+          image: busybox:1.36
+      containers:
+        - name: app
+          image: ghcr.io/org/app:v1
+        - name: sidecar
+          image: envoyproxy/envoy:v1.30
+---
+apiVersion: batch/v1
+kind: CronJob
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: job
+              image: ghcr.io/org/app:v1
+            - name: backup
+              image: postgres:16
+---
+apiVersion: v1
+kind: List
+items:
+  - kind: Pod
+    spec:
+      containers:
+        - image: alpine
+`
+	refs, err := ExtractImageRefs([]byte(manifests))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"busybox:1.36", "ghcr.io/org/app:v1", "envoyproxy/envoy:v1.30", "postgres:16", "alpine"}
+	if !reflect.DeepEqual(refs, want) {
+		t.Fatalf("refs = %v, want %v", refs, want)
+	}
+
+	if _, err := ExtractImageRefs([]byte("services: [")); err == nil {
+		t.Fatal("expected YAML error")
+	}
+}
+
+func TestHandleImageExtractAppliesAccessControl(t *testing.T) {
+	loadTestConfig(t, `
+[access]
+blackList = ["blocked/*"]
+`)
+	gin.SetMode(gin.TestMode)
+
+	body := `
+services:
+  ok:
+    image: nginx
+  bad:
+    image: blocked/app:v1
+  broken:
+    image: ghcr.io/org/app:${TAG}
+`
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/image/extract", strings.NewReader(body))
+	handleImageExtract(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Images  []string
+		Denied  []DeniedImage
+		Invalid []string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp.Images, []string{"nginx:latest"}) {
+		t.Fatalf("images = %v", resp.Images)
+	}
+	if len(resp.Denied) != 1 || resp.Denied[0].Image != "blocked/app:v1" {
+		t.Fatalf("denied = %v", resp.Denied)
+	}
+	if len(resp.Invalid) != 1 {
+		t.Fatalf("invalid = %v", resp.Invalid)
+	}
+}
+
+func TestNormalizeBundleImagesDedupsEquivalentRefs(t *testing.T) {
+	loadTestConfig(t, `
+[registries."registry.k8s.io"]
+upstream = "registry.k8s.io"
+enabled = true
+aliases = ["k8s"]
+`)
+
+	allowed, denied, invalid := normalizeBundleImages([]string{
+		"nginx",
+		"nginx:latest",
+		"docker.io/library/nginx:latest",
+		"k8s/pause:3.9",
+		"registry.k8s.io/pause:3.9",
+	})
+	want := []string{"nginx:latest", "registry.k8s.io/pause:3.9"}
+	if !reflect.DeepEqual(allowed, want) {
+		t.Fatalf("allowed = %v, want %v", allowed, want)
+	}
+	if len(denied) != 0 || len(invalid) != 0 {
+		t.Fatalf("denied = %v, invalid = %v", denied, invalid)
+	}
+}
@@ -2,7 +2,7 @@ package handlers
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"bytes"
 	"context"
 	"crypto/md5"
 	"crypto/rand"
@@ -14,18 +14,20 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"runtime"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/partial"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
 	"hubproxy/config"
 	"hubproxy/utils"
 )
@@ -142,12 +144,19 @@ type BatchDownloadRequest struct {
 	Images              []string
 	Platform            string
 	UseCompressedLayers bool
+	Compression         CompressionType
+	Retag               map[string]string
+	RetagPrefix         string
+	SkipFailed          bool
 }
 
 type SingleDownloadRequest struct {
 	Image               string
 	Platform            string
 	UseCompressedLayers bool
+	Compression         CompressionType
+	RetagTarget         string
+	RetagPrefix         string
 }
 
 type tokenEntry[T any] struct {
@@ -232,8 +241,7 @@ var singleDownloadTokens = newTokenStore[SingleDownloadRequest]()
 
 // ImageStreamer 镜像流式下载器
 type ImageStreamer struct {
-	concurrency   int
-	remoteOptions []remote.Option
+	concurrency int
 }
 
 // ImageStreamerConfig 下载器配置
@@ -256,67 +264,417 @@ func NewImageStreamer(cfg *ImageStreamerConfig) *ImageStreamer {
 		}
 	}
 
-	remoteOptions := []remote.Option{
-		remote.WithAuth(authn.Anonymous),
-		remote.WithTransport(utils.GetGlobalHTTPClient().Transport),
+	return &ImageStreamer{
+		concurrency: concurrency,
 	}
+}
 
-	return &ImageStreamer{
-		concurrency:   concurrency,
-		remoteOptions: remoteOptions,
+// imageSource 经 [registries] 映射解析后的镜像，manifest 与 /v2 代理共享缓存
+type imageSource struct {
+	ctx      context.Context
+	target   *upstreamTarget
+	manifest *utils.CachedItem
+}
+
+// resolveImageSource 解析镜像引用并获取其manifest
+func resolveImageSource(ctx context.Context, imageRef string) (*imageSource, error) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("解析镜像引用失败: %w", err)
+	}
+
+	target, err := resolveUpstreamTarget(ref.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := fetchManifest(ctx, target.repository.Name(), ref.Identifier(), nil, target.options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &imageSource{ctx: ctx, target: target, manifest: manifest}, nil
+}
+
+// checkImageAccess 按映射后的 Registry 与仓库名做访问控制，与 /v2 代理的判定保持一致
+func checkImageAccess(imageRef string) (bool, string) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return false, "镜像引用格式错误: " + err.Error()
+	}
+
+	target, err := resolveUpstreamTarget(ref.Context())
+	if err != nil {
+		return false, err.Error()
+	}
+
+	return utils.GlobalAccessController.CheckDockerAccess(target.accessName)
+}
+
+func (src *imageSource) mediaType() types.MediaType {
+	return types.MediaType(src.manifest.ContentType)
+}
+
+func (src *imageSource) digest() string {
+	return src.manifest.Headers["Docker-Content-Digest"]
+}
+
+func (src *imageSource) isIndex() bool {
+	mediaType := src.mediaType()
+	return mediaType == types.OCIImageIndex || mediaType == types.DockerManifestList
+}
+
+func (src *imageSource) indexManifest() (*v1.IndexManifest, error) {
+	return v1.ParseIndexManifest(bytes.NewReader(src.manifest.Data))
+}
+
+// image 返回单架构镜像
+func (src *imageSource) image() (v1.Image, error) {
+	return newCachedImage(src.ctx, src.target, src.manifest)
+}
+
+// childImage 返回多架构索引中指定digest的镜像
+func (src *imageSource) childImage(digest v1.Hash) (v1.Image, error) {
+	manifest, err := fetchManifest(src.ctx, src.target.repository.Name(), digest.String(), nil, src.target.options)
+	if err != nil {
+		return nil, err
 	}
+	return newCachedImage(src.ctx, src.target, manifest)
+}
+
+// cachedImage 基于缓存manifest构建的镜像，config 写入共享 blob 缓存，layer 按需从上游拉取
+type cachedImage struct {
+	ctx      context.Context
+	target   *upstreamTarget
+	manifest *utils.CachedItem
+	parsed   *v1.Manifest
+}
+
+func newCachedImage(ctx context.Context, target *upstreamTarget, manifest *utils.CachedItem) (v1.Image, error) {
+	parsed, err := v1.ParseManifest(bytes.NewReader(manifest.Data))
+	if err != nil {
+		return nil, fmt.Errorf("解析镜像manifest失败: %w", err)
+	}
+
+	return partial.CompressedToImage(&cachedImage{
+		ctx:      ctx,
+		target:   target,
+		manifest: manifest,
+		parsed:   parsed,
+	})
+}
+
+func (ci *cachedImage) RawManifest() ([]byte, error) {
+	return ci.manifest.Data, nil
+}
+
+func (ci *cachedImage) MediaType() (types.MediaType, error) {
+	return types.MediaType(ci.manifest.ContentType), nil
+}
+
+func (ci *cachedImage) RawConfigFile() ([]byte, error) {
+	digest := ci.parsed.Config.Digest.String()
+	cacheKey := utils.BuildBlobCacheKey(ci.target.cacheScope, digest)
+	if utils.IsCacheEnabled() {
+		if cachedItem := utils.GlobalCache.Get(cacheKey); cachedItem != nil {
+			return cachedItem.Data, nil
+		}
+	}
+
+	layer, err := remote.Layer(ci.target.repository.Digest(digest), ci.target.contextOptions(ci.ctx)...)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if utils.IsCacheEnabled() && len(data) <= utils.MaxCachedBlobSize {
+		utils.GlobalCache.Set(cacheKey, data, "application/octet-stream", nil, utils.GetManifestTTL(digest))
+	}
+	return data, nil
+}
+
+func (ci *cachedImage) LayerByDigest(h v1.Hash) (partial.CompressedLayer, error) {
+	for _, desc := range ci.parsed.Layers {
+		if desc.Digest != h {
+			continue
+		}
+		layer, err := remote.Layer(ci.target.repository.Digest(h.String()), ci.target.contextOptions(ci.ctx)...)
+		if err != nil {
+			return nil, err
+		}
+		return &describedLayer{Layer: layer, desc: desc}, nil
+	}
+	return nil, fmt.Errorf("镜像中不存在层 %s", h)
+}
+
+// describedLayer 使用manifest中的大小与媒体类型，避免逐层 HEAD 上游
+type describedLayer struct {
+	v1.Layer
+	desc v1.Descriptor
+}
+
+func (l *describedLayer) Size() (int64, error) {
+	return l.desc.Size, nil
+}
+
+func (l *describedLayer) MediaType() (types.MediaType, error) {
+	return l.desc.MediaType, nil
 }
 
 // StreamOptions 下载选项
 type StreamOptions struct {
 	Platform            string
-	Compression         bool
+	Compression         CompressionType
 	UseCompressedLayers bool
+	Retag               *RetagRules
+	SkipFailed          bool
 }
 
-// StreamImageToWriter 流式下载镜像到Writer
-func (is *ImageStreamer) StreamImageToWriter(ctx context.Context, imageRef string, writer io.Writer, options *StreamOptions) error {
-	if options == nil {
-		options = &StreamOptions{UseCompressedLayers: true}
+// 批量下载报告中的镜像状态
+const (
+	batchStatusSuccess = "success"
+	batchStatusFailed  = "failed"
+	batchStatusDenied  = "denied"
+)
+
+// batchReportFile 批量下载报告在 tar 中的文件名
+const batchReportFile = "hubproxy-report.json"
+
+// BatchImageReport 批量下载中单个镜像的处理结果
+type BatchImageReport struct {
+	Image    string `json:"image"`
+	Status   string `json:"status"`
+	Digest   string `json:"digest,omitempty"`
+	Platform string `json:"platform,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// BatchReport 批量下载报告
+type BatchReport struct {
+	CreatedAt time.Time          `json:"createdAt"`
+	Total     int                `json:"total"`
+	Succeeded int                `json:"succeeded"`
+	Failed    int                `json:"failed"`
+	Images    []BatchImageReport `json:"images"`
+}
+
+// imageArchiveEntry 单个镜像写入 tar 后的元数据
+type imageArchiveEntry struct {
+	manifest     map[string]interface{}
+	repositories map[string]map[string]string
+	digest       string
+	platform     string
+	size         int64
+}
+
+// RetagRules 导出离线包时改写镜像名，使 docker load 后直接得到内网仓库的标签
+type RetagRules struct {
+	targets map[string]string
+	prefix  string
+}
+
+// NewRetagRules 创建改写规则；targets 为 源镜像→目标镜像 的显式映射，优先于 prefix
+func NewRetagRules(targets map[string]string, prefix string) (*RetagRules, error) {
+	prefix = strings.TrimSuffix(strings.TrimSpace(prefix), "/")
+	if prefix != "" {
+		if _, err := name.NewRepository(prefix + "/image"); err != nil {
+			return nil, fmt.Errorf("无效的改写前缀 %s: %w", prefix, err)
+		}
+	}
+
+	rules := &RetagRules{
+		targets: make(map[string]string, len(targets)),
+		prefix:  prefix,
+	}
+	for source, target := range targets {
+		source = strings.TrimSpace(source)
+		target = strings.TrimSpace(target)
+		if source == "" || target == "" {
+			continue
+		}
+		sourceRef, err := name.ParseReference(expandRegistryAlias(source))
+		if err != nil {
+			return nil, fmt.Errorf("无效的源镜像 %s: %w", source, err)
+		}
+		if _, err := name.ParseReference(target); err != nil {
+			return nil, fmt.Errorf("无效的目标镜像 %s: %w", target, err)
+		}
+		rules.targets[sourceRef.Name()] = target
+	}
+
+	if len(rules.targets) == 0 && rules.prefix == "" {
+		return nil, nil
+	}
+	return rules, nil
+}
+
+// Resolve 返回写入 RepoTags 的镜像名，未命中规则时保持原样
+func (r *RetagRules) Resolve(imageRef string) string {
+	if r == nil {
+		return imageRef
 	}
 
 	ref, err := name.ParseReference(imageRef)
 	if err != nil {
-		return fmt.Errorf("解析镜像引用失败: %w", err)
+		return imageRef
 	}
 
-	log.Printf("开始下载镜像: %s", ref.String())
+	if target, ok := r.targets[ref.Name()]; ok {
+		if _, _, hasTag := splitRepoTag(target); hasTag || strings.Contains(target, "@") {
+			return target
+		}
+		return target + referenceSuffix(ref)
+	}
 
-	contextOptions := append(is.remoteOptions, remote.WithContext(ctx))
+	if r.prefix != "" {
+		return r.prefix + "/" + ref.Context().RepositoryStr() + referenceSuffix(ref)
+	}
 
-	desc, err := is.getImageDescriptor(ref, contextOptions)
-	if err != nil {
-		return fmt.Errorf("获取镜像描述失败: %w", err)
+	return imageRef
+}
+
+// referenceSuffix 返回引用的 :tag 或 @digest 部分
+func referenceSuffix(ref name.Reference) string {
+	if digest, ok := ref.(name.Digest); ok {
+		return "@" + digest.DigestStr()
 	}
-	switch desc.MediaType {
-	case types.OCIImageIndex, types.DockerManifestList:
-		return is.streamMultiArchImage(ctx, desc, writer, options, imageRef)
-	case types.OCIManifestSchema1, types.DockerManifestSchema2:
-		return is.streamSingleImage(ctx, desc, writer, options, imageRef)
+	return ":" + ref.Identifier()
+}
+
+// splitRepoTag 按最后一个不含 / 的冒号拆分仓库与标签，兼容带端口的 Registry
+func splitRepoTag(imageRef string) (string, string, bool) {
+	idx := strings.LastIndex(imageRef, ":")
+	if idx == -1 || strings.Contains(imageRef[idx+1:], "/") || strings.Contains(imageRef, "@") {
+		return imageRef, "", false
+	}
+	return imageRef[:idx], imageRef[idx+1:], true
+}
+
+// CompressionType 离线包整体压缩格式
+type CompressionType string
+
+const (
+	CompressionNone CompressionType = "none"
+	CompressionGzip CompressionType = "gzip"
+	CompressionZstd CompressionType = "zstd"
+)
+
+// parseCompression 解析 compression 参数，空值等同 none
+func parseCompression(value string) (CompressionType, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "none":
+		return CompressionNone, nil
+	case "gzip", "gz":
+		return CompressionGzip, nil
+	case "zstd", "zst":
+		return CompressionZstd, nil
+	default:
+		return "", fmt.Errorf("不支持的压缩格式: %s", value)
+	}
+}
+
+// archiveExtension 返回离线包文件扩展名
+func (ct CompressionType) archiveExtension() string {
+	switch ct {
+	case CompressionGzip:
+		return ".tar.gz"
+	case CompressionZstd:
+		return ".tar.zst"
 	default:
-		return is.streamSingleImage(ctx, desc, writer, options, imageRef)
+		return ".tar"
 	}
 }
 
-// getImageDescriptor 获取镜像描述符
-func (is *ImageStreamer) getImageDescriptor(ref name.Reference, options []remote.Option) (*remote.Descriptor, error) {
-	return remote.Get(ref, options...)
+// contentType 返回离线包的 Content-Type
+func (ct CompressionType) contentType() string {
+	switch ct {
+	case CompressionGzip:
+		return "application/gzip"
+	case CompressionZstd:
+		return "application/zstd"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newArchiveWriter 按压缩格式包装输出流，gzip/zstd 均多线程编码；调用方需 Close 以写出压缩尾部
+func newArchiveWriter(writer io.Writer, compression CompressionType) (io.WriteCloser, error) {
+	cfg := config.GetConfig()
+	level := cfg.Download.CompressionLevel
+	threads := cfg.Download.CompressionThreads
+	if threads <= 0 {
+		threads = runtime.GOMAXPROCS(0)
+	}
+
+	switch compression {
+	case CompressionGzip:
+		if level <= 0 || level > pgzip.BestCompression {
+			level = pgzip.DefaultCompression
+		}
+		gzWriter, err := pgzip.NewWriterLevel(writer, level)
+		if err != nil {
+			return nil, fmt.Errorf("创建gzip压缩器失败: %w", err)
+		}
+		if err := gzWriter.SetConcurrency(1<<20, threads); err != nil {
+			return nil, fmt.Errorf("设置gzip并发失败: %w", err)
+		}
+		return gzWriter, nil
+	case CompressionZstd:
+		encoderOptions := []zstd.EOption{zstd.WithEncoderConcurrency(threads)}
+		if level > 0 {
+			encoderOptions = append(encoderOptions, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		zstdWriter, err := zstd.NewWriter(writer, encoderOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("创建zstd压缩器失败: %w", err)
+		}
+		return zstdWriter, nil
+	default:
+		return nopWriteCloser{writer}, nil
+	}
 }
 
-func setDownloadHeaders(c *gin.Context, filename string, compressed bool) {
-	c.Header("Content-Type", "application/octet-stream")
+// StreamImageToWriter 流式下载镜像到Writer
+func (is *ImageStreamer) StreamImageToWriter(ctx context.Context, imageRef string, writer io.Writer, options *StreamOptions) error {
+	if options == nil {
+		options = &StreamOptions{UseCompressedLayers: true}
+	}
+
+	log.Printf("开始下载镜像: %s", imageRef)
+
+	src, err := resolveImageSource(ctx, imageRef)
+	if err != nil {
+		return fmt.Errorf("获取镜像描述失败: %w", err)
+	}
+	if src.isIndex() {
+		return is.streamMultiArchImage(ctx, src, writer, options, imageRef)
+	}
+	return is.streamSingleImage(ctx, src, writer, options, imageRef)
+}
+
+// setDownloadHeaders 压缩包以 .tar.gz/.tar.zst 文件形式下载，不设置 Content-Encoding，避免客户端自动解压
+func setDownloadHeaders(c *gin.Context, filename string, compression CompressionType) {
+	c.Header("Content-Type", compression.contentType())
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
 	c.Header("Cache-Control", "no-store")
 	c.Header("Pragma", "no-cache")
 	c.Header("Expires", "0")
-	if compressed {
-		c.Header("Content-Encoding", "gzip")
-	}
 }
 
 // writeDownloadError 仅在尚未写出响应体时返回 JSON；流已开始则只记日志，避免损坏 tar。
@@ -330,37 +688,61 @@ func writeDownloadError(c *gin.Context, err error, message string) {
 
 // StreamImageToGin 流式响应到Gin
 func (is *ImageStreamer) StreamImageToGin(ctx context.Context, imageRef string, c *gin.Context, options *StreamOptions) error {
+	defer utils.BeginTransfer()()
+
 	if options == nil {
 		options = &StreamOptions{UseCompressedLayers: true}
 	}
 
-	ref, err := name.ParseReference(imageRef)
-	if err != nil {
-		return fmt.Errorf("解析镜像引用失败: %w", err)
-	}
-
-	contextOptions := append(is.remoteOptions, remote.WithContext(ctx))
-	desc, err := is.getImageDescriptor(ref, contextOptions)
+	src, err := resolveImageSource(ctx, imageRef)
 	if err != nil {
 		return fmt.Errorf("获取镜像描述失败: %w", err)
 	}
 
-	filename := strings.ReplaceAll(imageRef, "/", "_") + ".tar"
+	filename := strings.ReplaceAll(imageRef, "/", "_") + options.Compression.archiveExtension()
 	setDownloadHeaders(c, filename, options.Compression)
 
-	switch desc.MediaType {
-	case types.OCIImageIndex, types.DockerManifestList:
-		return is.streamMultiArchImage(ctx, desc, c.Writer, options, imageRef)
-	case types.OCIManifestSchema1, types.DockerManifestSchema2:
-		return is.streamSingleImage(ctx, desc, c.Writer, options, imageRef)
-	default:
-		return is.streamSingleImage(ctx, desc, c.Writer, options, imageRef)
+	start := time.Now()
+	counter := &exportCounter{Writer: c.Writer}
+	if src.isIndex() {
+		err = is.streamMultiArchImage(ctx, src, counter, options, imageRef)
+	} else {
+		err = is.streamSingleImage(ctx, src, counter, options, imageRef)
+	}
+	if err == nil {
+		emitExportCompleted([]string{imageRef}, counter.n, time.Since(start), 1, 0, options)
 	}
+	return err
+}
+
+// exportCounter 统计离线导出写出的字节数
+type exportCounter struct {
+	io.Writer
+	n int64
+}
+
+func (w *exportCounter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// emitExportCompleted 发出离线导出完成的 webhook 事件
+func emitExportCompleted(images []string, size int64, duration time.Duration, succeeded, failed int, options *StreamOptions) {
+	utils.EmitExportEvent(size, gin.H{
+		"images":       images,
+		"bytes":        size,
+		"duration_sec": duration.Seconds(),
+		"succeeded":    succeeded,
+		"failed":       failed,
+		"compression":  string(options.Compression),
+		"platform":     options.Platform,
+	})
 }
 
 // streamMultiArchImage 处理多架构镜像
-func (is *ImageStreamer) streamMultiArchImage(ctx context.Context, desc *remote.Descriptor, writer io.Writer, options *StreamOptions, imageRef string) error {
-	img, err := is.selectPlatformImage(desc, options)
+func (is *ImageStreamer) streamMultiArchImage(ctx context.Context, src *imageSource, writer io.Writer, options *StreamOptions, imageRef string) error {
+	img, err := is.selectPlatformImage(src, options)
 	if err != nil {
 		return err
 	}
@@ -369,8 +751,8 @@ func (is *ImageStreamer) streamMultiArchImage(ctx context.Context, desc *remote.
 }
 
 // streamSingleImage 处理单架构镜像
-func (is *ImageStreamer) streamSingleImage(ctx context.Context, desc *remote.Descriptor, writer io.Writer, options *StreamOptions, imageRef string) error {
-	img, err := desc.Image()
+func (is *ImageStreamer) streamSingleImage(ctx context.Context, src *imageSource, writer io.Writer, options *StreamOptions, imageRef string) error {
+	img, err := src.image()
 	if err != nil {
 		return fmt.Errorf("获取镜像失败: %w", err)
 	}
@@ -380,15 +762,13 @@ func (is *ImageStreamer) streamSingleImage(ctx context.Context, desc *remote.Des
 
 // streamImageLayers 处理镜像层
 func (is *ImageStreamer) streamImageLayers(ctx context.Context, img v1.Image, writer io.Writer, options *StreamOptions, imageRef string) error {
-	var finalWriter io.Writer = writer
-
-	if options.Compression {
-		gzWriter := gzip.NewWriter(writer)
-		defer gzWriter.Close()
-		finalWriter = gzWriter
+	archiveWriter, err := newArchiveWriter(writer, options.Compression)
+	if err != nil {
+		return err
 	}
+	defer archiveWriter.Close()
 
-	tarWriter := tar.NewWriter(finalWriter)
+	tarWriter := tar.NewWriter(archiveWriter)
 	defer tarWriter.Close()
 
 	configFile, err := img.ConfigFile()
@@ -408,11 +788,11 @@ func (is *ImageStreamer) streamImageLayers(ctx context.Context, img v1.Image, wr
 
 // streamDockerFormat 生成Docker格式
 func (is *ImageStreamer) streamDockerFormat(ctx context.Context, tarWriter *tar.Writer, img v1.Image, layers []v1.Layer, configFile *v1.ConfigFile, imageRef string, options *StreamOptions) error {
-	return is.streamDockerFormatWithReturn(ctx, tarWriter, img, layers, configFile, imageRef, nil, nil, options)
+	return is.streamDockerFormatWithReturn(ctx, tarWriter, img, layers, configFile, imageRef, nil, options)
 }
 
-// streamDockerFormatWithReturn 生成Docker格式并返回manifest和repositories信息
-func (is *ImageStreamer) streamDockerFormatWithReturn(ctx context.Context, tarWriter *tar.Writer, img v1.Image, layers []v1.Layer, configFile *v1.ConfigFile, imageRef string, manifestOut *map[string]interface{}, repositoriesOut *map[string]map[string]string, options *StreamOptions) error {
+// streamDockerFormatWithReturn 生成Docker格式；entryOut 非空时返回manifest等信息而不写入 manifest.json
+func (is *ImageStreamer) streamDockerFormatWithReturn(ctx context.Context, tarWriter *tar.Writer, img v1.Image, layers []v1.Layer, configFile *v1.ConfigFile, imageRef string, entryOut *imageArchiveEntry, options *StreamOptions) error {
 	configDigest, err := img.ConfigName()
 	if err != nil {
 		return err
@@ -435,6 +815,7 @@ func (is *ImageStreamer) streamDockerFormatWithReturn(ctx context.Context, tarWr
 	if _, err := tarWriter.Write(configData); err != nil {
 		return err
 	}
+	totalSize := int64(len(configData))
 
 	layerDigests := make([]string, len(layers))
 	for i, layer := range layers {
@@ -494,9 +875,14 @@ func (is *ImageStreamer) streamDockerFormatWithReturn(ctx context.Context, tarWr
 				return err
 			}
 
-			if _, err := io.Copy(tarWriter, layerReader); err != nil {
+			written, err := io.Copy(tarWriter, layerReader)
+			if err != nil {
+				if options != nil && options.SkipFailed {
+					return padTarEntry(tarWriter, layerSize-written, err)
+				}
 				return err
 			}
+			totalSize += written
 
 			return nil
 		}(); err != nil {
@@ -506,9 +892,16 @@ func (is *ImageStreamer) streamDockerFormatWithReturn(ctx context.Context, tarWr
 		log.Printf("已处理层 %d/%d", i+1, len(layers))
 	}
 
+	var repoTag string
+	if options != nil {
+		repoTag = options.Retag.Resolve(imageRef)
+	} else {
+		repoTag = imageRef
+	}
+
 	singleManifest := map[string]interface{}{
 		"Config":   configDigest.String() + ".json",
-		"RepoTags": []string{imageRef},
+		"RepoTags": []string{repoTag},
 		"Layers": func() []string {
 			var layers []string
 			for _, digest := range layerDigests {
@@ -519,16 +912,14 @@ func (is *ImageStreamer) streamDockerFormatWithReturn(ctx context.Context, tarWr
 	}
 
 	repositories := make(map[string]map[string]string)
-	parts := strings.Split(imageRef, ":")
-	if len(parts) == 2 {
-		repoName := parts[0]
-		tag := parts[1]
+	if repoName, tag, ok := splitRepoTag(repoTag); ok {
 		repositories[repoName] = map[string]string{tag: configDigest.String()}
 	}
 
-	if manifestOut != nil && repositoriesOut != nil {
-		*manifestOut = singleManifest
-		*repositoriesOut = repositories
+	if entryOut != nil {
+		entryOut.manifest = singleManifest
+		entryOut.repositories = repositories
+		entryOut.size = totalSize
 		return nil
 	}
 
@@ -572,61 +963,73 @@ func (is *ImageStreamer) streamDockerFormatWithReturn(ctx context.Context, tarWr
 	return err
 }
 
+// padTarEntry 层数据中途失败时用零字节补齐当前条目，保持 tar 结构完整以便继续写入后续镜像
+func padTarEntry(tarWriter *tar.Writer, remaining int64, cause error) error {
+	if remaining > 0 {
+		if _, err := io.CopyN(tarWriter, zeroReader{}, remaining); err != nil {
+			return fmt.Errorf("%w (补齐tar条目失败: %v)", cause, err)
+		}
+	}
+	return cause
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
 // processImageForBatch 处理镜像的公共逻辑
-func (is *ImageStreamer) processImageForBatch(ctx context.Context, img v1.Image, tarWriter *tar.Writer, imageRef string, options *StreamOptions) (map[string]interface{}, map[string]map[string]string, error) {
+func (is *ImageStreamer) processImageForBatch(ctx context.Context, img v1.Image, tarWriter *tar.Writer, imageRef string, options *StreamOptions) (*imageArchiveEntry, error) {
 	layers, err := img.Layers()
 	if err != nil {
-		return nil, nil, fmt.Errorf("获取镜像层失败: %w", err)
+		return nil, fmt.Errorf("获取镜像层失败: %w", err)
 	}
 
 	configFile, err := img.ConfigFile()
 	if err != nil {
-		return nil, nil, fmt.Errorf("获取镜像配置失败: %w", err)
+		return nil, fmt.Errorf("获取镜像配置失败: %w", err)
 	}
 
 	log.Printf("镜像包含 %d 层", len(layers))
 
-	var manifest map[string]interface{}
-	var repositories map[string]map[string]string
-
-	err = is.streamDockerFormatWithReturn(ctx, tarWriter, img, layers, configFile, imageRef, &manifest, &repositories, options)
-	if err != nil {
-		return nil, nil, err
+	entry := &imageArchiveEntry{}
+	if configFile.OS != "" {
+		entry.platform = configFile.OS + "/" + configFile.Architecture
+		if configFile.Variant != "" {
+			entry.platform += "/" + configFile.Variant
+		}
+	}
+	if digest, err := img.Digest(); err == nil {
+		entry.digest = digest.String()
 	}
 
-	return manifest, repositories, nil
-}
-
-func (is *ImageStreamer) streamSingleImageForBatch(ctx context.Context, tarWriter *tar.Writer, imageRef string, options *StreamOptions) (map[string]interface{}, map[string]map[string]string, error) {
-	ref, err := name.ParseReference(imageRef)
+	err = is.streamDockerFormatWithReturn(ctx, tarWriter, img, layers, configFile, imageRef, entry, options)
 	if err != nil {
-		return nil, nil, fmt.Errorf("解析镜像引用失败: %w", err)
+		return nil, err
 	}
 
-	contextOptions := append(is.remoteOptions, remote.WithContext(ctx))
+	return entry, nil
+}
 
-	desc, err := is.getImageDescriptor(ref, contextOptions)
+func (is *ImageStreamer) streamSingleImageForBatch(ctx context.Context, tarWriter *tar.Writer, imageRef string, options *StreamOptions) (*imageArchiveEntry, error) {
+	src, err := resolveImageSource(ctx, imageRef)
 	if err != nil {
-		return nil, nil, fmt.Errorf("获取镜像描述失败: %w", err)
+		return nil, fmt.Errorf("获取镜像描述失败: %w", err)
 	}
 
 	var img v1.Image
 
-	switch desc.MediaType {
-	case types.OCIImageIndex, types.DockerManifestList:
-		img, err = is.selectPlatformImage(desc, options)
-		if err != nil {
-			return nil, nil, fmt.Errorf("选择平台镜像失败: %w", err)
-		}
-	case types.OCIManifestSchema1, types.DockerManifestSchema2:
-		img, err = desc.Image()
+	if src.isIndex() {
+		img, err = is.selectPlatformImage(src, options)
 		if err != nil {
-			return nil, nil, fmt.Errorf("获取镜像失败: %w", err)
+			return nil, fmt.Errorf("选择平台镜像失败: %w", err)
 		}
-	default:
-		img, err = desc.Image()
+	} else {
+		img, err = src.image()
 		if err != nil {
-			return nil, nil, fmt.Errorf("获取镜像失败: %w", err)
+			return nil, fmt.Errorf("获取镜像失败: %w", err)
 		}
 	}
 
@@ -634,13 +1037,8 @@ func (is *ImageStreamer) streamSingleImageForBatch(ctx context.Context, tarWrite
 }
 
 // selectPlatformImage 从多架构镜像中选择合适的平台镜像
-func (is *ImageStreamer) selectPlatformImage(desc *remote.Descriptor, options *StreamOptions) (v1.Image, error) {
-	index, err := desc.ImageIndex()
-	if err != nil {
-		return nil, fmt.Errorf("获取镜像索引失败: %w", err)
-	}
-
-	manifest, err := index.IndexManifest()
+func (is *ImageStreamer) selectPlatformImage(src *imageSource, options *StreamOptions) (v1.Image, error) {
+	manifest, err := src.indexManifest()
 	if err != nil {
 		return nil, fmt.Errorf("获取索引清单失败: %w", err)
 	}
@@ -674,6 +1072,10 @@ func (is *ImageStreamer) selectPlatformImage(desc *remote.Descriptor, options *S
 		}
 	}
 
+	// 显式指定的平台不存在时报错，避免把其他架构的镜像当作成功导出
+	if selectedDesc == nil && options.Platform != "" {
+		return nil, fmt.Errorf("镜像不包含平台 %s", options.Platform)
+	}
 	if selectedDesc == nil && len(manifest.Manifests) > 0 {
 		selectedDesc = &manifest.Manifests[0]
 	}
@@ -682,7 +1084,7 @@ func (is *ImageStreamer) selectPlatformImage(desc *remote.Descriptor, options *S
 		return nil, fmt.Errorf("未找到合适的平台镜像")
 	}
 
-	img, err := index.Image(selectedDesc.Digest)
+	img, err := src.childImage(selectedDesc.Digest)
 	if err != nil {
 		return nil, fmt.Errorf("获取选中镜像失败: %w", err)
 	}
@@ -713,12 +1115,13 @@ func InitImageTarRoutes(router *gin.Engine) {
 		imageAPI.GET("/info", handleImageInfo)
 		imageAPI.GET("/batch", handleSimpleBatchDownload)
 		imageAPI.POST("/batch", handleSimpleBatchDownload)
+		imageAPI.POST("/extract", handleImageExtract)
 	}
 }
 
 // resolveImageRef 从 query image 读取镜像引用，避免 path 段用 _ 代替 / 导致下划线歧义。
 func resolveImageRef(c *gin.Context) string {
-	return strings.TrimSpace(c.Query("image"))
+	return expandRegistryAlias(strings.TrimSpace(c.Query("image")))
 }
 
 // handleDirectImageDownload 处理单镜像下载
@@ -732,6 +1135,13 @@ func handleDirectImageDownload(c *gin.Context) {
 	platform := c.Query("platform")
 	tag := c.DefaultQuery("tag", "")
 	useCompressed := c.DefaultQuery("compressed", "true") == "true"
+	compression, err := parseCompression(c.Query("compression"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	retagTarget := strings.TrimSpace(c.Query("retag"))
+	retagPrefix := strings.TrimSpace(c.Query("retagPrefix"))
 
 	if tag != "" && !strings.Contains(imageRef, ":") && !strings.Contains(imageRef, "@") {
 		imageRef = imageRef + ":" + tag
@@ -743,10 +1153,14 @@ func handleDirectImageDownload(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "镜像引用格式错误: " + err.Error()})
 		return
 	}
-	if allowed, reason := utils.GlobalAccessController.CheckDockerAccess(imageRef); !allowed {
+	if allowed, reason := checkImageAccess(imageRef); !allowed {
 		c.JSON(http.StatusForbidden, gin.H{"error": reason})
 		return
 	}
+	if _, err := NewRetagRules(map[string]string{imageRef: retagTarget}, retagPrefix); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	if c.Query("mode") == "prepare" {
 		userID := getUserID(c)
@@ -765,6 +1179,9 @@ func handleDirectImageDownload(c *gin.Context) {
 			Image:               imageRef,
 			Platform:            platform,
 			UseCompressedLayers: useCompressed,
+			Compression:         compression,
+			RetagTarget:         retagTarget,
+			RetagPrefix:         retagPrefix,
 		}, ip, userAgent)
 		if err != nil {
 			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
@@ -774,7 +1191,7 @@ func handleDirectImageDownload(c *gin.Context) {
 		q := url.Values{}
 		q.Set("image", imageRef)
 		q.Set("token", token)
-		c.JSON(http.StatusOK, gin.H{"download_url": "/api/image/download?" + q.Encode()})
+		c.JSON(http.StatusOK, gin.H{"download_url": utils.PublicBaseURL(c) + "/api/image/download?" + q.Encode()})
 		return
 	}
 
@@ -794,15 +1211,22 @@ func handleDirectImageDownload(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "下载令牌与镜像不匹配"})
 		return
 	}
-	if allowed, reason := utils.GlobalAccessController.CheckDockerAccess(req.Image); !allowed {
+	if allowed, reason := checkImageAccess(req.Image); !allowed {
 		c.JSON(http.StatusForbidden, gin.H{"error": reason})
 		return
 	}
 
+	retag, err := NewRetagRules(map[string]string{req.Image: req.RetagTarget}, req.RetagPrefix)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	options := &StreamOptions{
 		Platform:            req.Platform,
-		Compression:         false,
+		Compression:         req.Compression,
 		UseCompressedLayers: req.UseCompressedLayers,
+		Retag:               retag,
 	}
 
 	ctx := c.Request.Context()
@@ -835,16 +1259,24 @@ func handleSimpleBatchDownload(c *gin.Context) {
 			return
 		}
 
+		retag, err := NewRetagRules(req.Retag, req.RetagPrefix)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		options := &StreamOptions{
 			Platform:            req.Platform,
-			Compression:         false,
+			Compression:         req.Compression,
 			UseCompressedLayers: req.UseCompressedLayers,
+			Retag:               retag,
+			SkipFailed:          req.SkipFailed,
 		}
 
 		ctx := c.Request.Context()
 		log.Printf("批量下载 %d 个镜像 (平台: %s)", len(req.Images), formatPlatformText(req.Platform))
 
-		filename := fmt.Sprintf("batch_%d_images.tar", len(req.Images))
+		filename := fmt.Sprintf("batch_%d_images%s", len(req.Images), options.Compression.archiveExtension())
 		setDownloadHeaders(c, filename, options.Compression)
 
 		if err := globalImageStreamer.StreamMultipleImages(ctx, req.Images, c.Writer, options); err != nil {
@@ -860,9 +1292,14 @@ func handleSimpleBatchDownload(c *gin.Context) {
 	}
 
 	var req struct {
-		Images              []string `json:"images" binding:"required"`
-		Platform            string   `json:"platform"`
-		UseCompressedLayers *bool    `json:"useCompressedLayers"`
+		Images              []string          `json:"images"`
+		Manifest            string            `json:"manifest"`
+		Platform            string            `json:"platform"`
+		UseCompressedLayers *bool             `json:"useCompressedLayers"`
+		Compression         string            `json:"compression"`
+		Retag               map[string]string `json:"retag"`
+		RetagPrefix         string            `json:"retagPrefix"`
+		SkipFailed          bool              `json:"skipFailed"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -870,66 +1307,109 @@ func handleSimpleBatchDownload(c *gin.Context) {
 		return
 	}
 
-	if len(req.Images) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "镜像列表不能为空"})
-		return
-	}
-	for _, imageRef := range req.Images {
-		if allowed, reason := utils.GlobalAccessController.CheckDockerAccess(imageRef); !allowed {
-			c.JSON(http.StatusForbidden, gin.H{"error": reason})
+	// manifest 为 compose 或 Kubernetes YAML，提取出的镜像与 images 合并
+	if req.Manifest != "" {
+		if len(req.Manifest) > maxBundleManifestSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "清单内容过大"})
 			return
 		}
-	}
-
-	for i, imageRef := range req.Images {
-		if !strings.Contains(imageRef, ":") && !strings.Contains(imageRef, "@") {
-			req.Images[i] = imageRef + ":latest"
+		refs, err := ExtractImageRefs([]byte(req.Manifest))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
+		req.Images = append(req.Images, refs...)
 	}
+
+	// 展开别名、补全默认 tag 后按规范名去重；无法解析的引用原样保留，由访问控制或下载报告给出错误
+	images := make([]string, 0, len(req.Images))
+	seen := make(map[string]bool, len(req.Images))
 	for _, imageRef := range req.Images {
-		if allowed, reason := utils.GlobalAccessController.CheckDockerAccess(imageRef); !allowed {
-			c.JSON(http.StatusForbidden, gin.H{"error": reason})
-			return
+		ref, key, _ := normalizeImageRef(imageRef)
+		if !seen[key] {
+			seen[key] = true
+			images = append(images, ref)
 		}
 	}
+	req.Images = images
 
-	cfg := config.GetConfig()
-	if len(req.Images) > cfg.Download.MaxImages {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": fmt.Sprintf("镜像数量超过限制，最大允许: %d", cfg.Download.MaxImages),
-		})
+	if len(req.Images) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "镜像列表不能为空"})
 		return
 	}
-
-	userID := getUserID(c)
-	contentKey := generateContentFingerprint(req.Images, req.Platform)
-
-	if !batchImageDebouncer.ShouldAllow(userID, contentKey) {
-		c.JSON(http.StatusTooManyRequests, gin.H{
-			"error":       "批量下载请求过于频繁，请稍后再试",
-			"retry_after": 60,
-		})
+	compression, err := parseCompression(req.Compression)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// skipFailed 时被拒绝的镜像记入下载报告，不拒绝整个请求
+	if !req.SkipFailed {
+		for _, imageRef := range req.Images {
+			if allowed, reason := checkImageAccess(imageRef); !allowed {
+				c.JSON(http.StatusForbidden, gin.H{"error": reason})
+				return
+			}
+		}
+	}
 
 	useCompressed := true
 	if req.UseCompressedLayers != nil {
 		useCompressed = *req.UseCompressedLayers
 	}
 
-	batchReq := BatchDownloadRequest{
+	downloadURL, status, errBody := prepareBatchDownload(c, BatchDownloadRequest{
 		Images:              req.Images,
 		Platform:            req.Platform,
 		UseCompressedLayers: useCompressed,
+		Compression:         compression,
+		Retag:               req.Retag,
+		RetagPrefix:         req.RetagPrefix,
+		SkipFailed:          req.SkipFailed,
+	})
+	if errBody != nil {
+		c.JSON(status, errBody)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"download_url": downloadURL})
+}
+
+// prepareBatchDownload 校验已补全 tag 的批量请求并签发下载令牌，失败时返回状态码与错误响应
+func prepareBatchDownload(c *gin.Context, batchReq BatchDownloadRequest) (string, int, gin.H) {
+	if !batchReq.SkipFailed {
+		for _, imageRef := range batchReq.Images {
+			if allowed, reason := checkImageAccess(imageRef); !allowed {
+				return "", http.StatusForbidden, gin.H{"error": reason}
+			}
+		}
+	}
+
+	if _, err := NewRetagRules(batchReq.Retag, batchReq.RetagPrefix); err != nil {
+		return "", http.StatusBadRequest, gin.H{"error": err.Error()}
+	}
+
+	cfg := config.GetConfig()
+	if len(batchReq.Images) > cfg.Download.MaxImages {
+		return "", http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("镜像数量超过限制，最大允许: %d", cfg.Download.MaxImages),
+		}
+	}
+
+	userID := getUserID(c)
+	contentKey := generateContentFingerprint(batchReq.Images, batchReq.Platform)
+
+	if !batchImageDebouncer.ShouldAllow(userID, contentKey) {
+		return "", http.StatusTooManyRequests, gin.H{
+			"error":       "批量下载请求过于频繁，请稍后再试",
+			"retry_after": 60,
+		}
 	}
 
 	ip, userAgent := getClientIdentity(c)
 	token, err := batchDownloadTokens.create(batchReq, ip, userAgent)
 	if err != nil {
-		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
-		return
+		return "", http.StatusTooManyRequests, gin.H{"error": err.Error()}
 	}
-	c.JSON(http.StatusOK, gin.H{"download_url": fmt.Sprintf("/api/image/batch?token=%s", token)})
+	return fmt.Sprintf("%s/api/image/batch?token=%s", utils.PublicBaseURL(c), token), http.StatusOK, nil
 }
 
 // handleImageInfo 处理镜像信息查询
@@ -951,15 +1431,12 @@ func handleImageInfo(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "镜像引用格式错误: " + err.Error()})
 		return
 	}
-	if allowed, reason := utils.GlobalAccessController.CheckDockerAccess(imageRef); !allowed {
+	if allowed, reason := checkImageAccess(imageRef); !allowed {
 		c.JSON(http.StatusForbidden, gin.H{"error": reason})
 		return
 	}
 
-	ctx := c.Request.Context()
-	contextOptions := append(globalImageStreamer.remoteOptions, remote.WithContext(ctx))
-
-	desc, err := globalImageStreamer.getImageDescriptor(ref, contextOptions)
+	src, err := resolveImageSource(c.Request.Context(), imageRef)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取镜像信息失败: " + err.Error()})
 		return
@@ -967,25 +1444,22 @@ func handleImageInfo(c *gin.Context) {
 
 	info := gin.H{
 		"name":      ref.String(),
-		"mediaType": desc.MediaType,
-		"digest":    desc.Digest.String(),
-		"size":      desc.Size,
+		"mediaType": src.mediaType(),
+		"digest":    src.digest(),
+		"size":      len(src.manifest.Data),
 	}
 
-	if desc.MediaType == types.OCIImageIndex || desc.MediaType == types.DockerManifestList {
-		index, err := desc.ImageIndex()
+	if src.isIndex() {
+		manifest, err := src.indexManifest()
 		if err == nil {
-			manifest, err := index.IndexManifest()
-			if err == nil {
-				var platforms []string
-				for _, m := range manifest.Manifests {
-					if m.Platform != nil {
-						platforms = append(platforms, m.Platform.OS+"/"+m.Platform.Architecture)
-					}
+			var platforms []string
+			for _, m := range manifest.Manifests {
+				if m.Platform != nil {
+					platforms = append(platforms, m.Platform.OS+"/"+m.Platform.Architecture)
 				}
-				info["platforms"] = platforms
-				info["multiArch"] = true
 			}
+			info["platforms"] = platforms
+			info["multiArch"] = true
 		}
 	} else {
 		info["multiArch"] = false
@@ -996,21 +1470,37 @@ func handleImageInfo(c *gin.Context) {
 
 // StreamMultipleImages 批量下载多个镜像
 func (is *ImageStreamer) StreamMultipleImages(ctx context.Context, imageRefs []string, writer io.Writer, options *StreamOptions) error {
+	defer utils.BeginTransfer()()
+
 	if options == nil {
 		options = &StreamOptions{UseCompressedLayers: true}
 	}
 
-	var finalWriter io.Writer = writer
-	if options.Compression {
-		gzWriter := gzip.NewWriter(writer)
-		defer gzWriter.Close()
-		finalWriter = gzWriter
+	report := &BatchReport{
+		CreatedAt: time.Now().UTC(),
+		Total:     len(imageRefs),
+		Images:    make([]BatchImageReport, 0, len(imageRefs)),
 	}
 
-	tarWriter := tar.NewWriter(finalWriter)
+	// 在压缩流关闭后发出完成事件，字节数包含 tar 结尾与压缩尾部
+	counter := &exportCounter{Writer: writer}
+	completed := false
+	defer func() {
+		if completed {
+			emitExportCompleted(imageRefs, counter.n, time.Since(report.CreatedAt), report.Succeeded, report.Failed, options)
+		}
+	}()
+
+	archiveWriter, err := newArchiveWriter(counter, options.Compression)
+	if err != nil {
+		return err
+	}
+	defer archiveWriter.Close()
+
+	tarWriter := tar.NewWriter(archiveWriter)
 	defer tarWriter.Close()
 
-	var allManifests []map[string]interface{}
+	allManifests := make([]map[string]interface{}, 0, len(imageRefs))
 	var allRepositories = make(map[string]map[string]string)
 
 	for i, imageRef := range imageRefs {
@@ -1022,22 +1512,51 @@ func (is *ImageStreamer) StreamMultipleImages(ctx context.Context, imageRefs []s
 
 		log.Printf("处理镜像 %d/%d: %s", i+1, len(imageRefs), imageRef)
 
+		if allowed, reason := checkImageAccess(imageRef); !allowed {
+			if !options.SkipFailed {
+				return fmt.Errorf("镜像 %s 访问被拒绝: %s", imageRef, reason)
+			}
+			log.Printf("跳过镜像 %s: %s", imageRef, reason)
+			report.Failed++
+			report.Images = append(report.Images, BatchImageReport{Image: imageRef, Status: batchStatusDenied, Error: reason})
+			continue
+		}
+
 		timeoutCtx, cancel := context.WithTimeout(ctx, 15*time.Minute)
-		manifest, repositories, err := is.streamSingleImageForBatch(timeoutCtx, tarWriter, imageRef, options)
+		entry, err := is.streamSingleImageForBatch(timeoutCtx, tarWriter, imageRef, options)
 		cancel()
 
+		if err == nil && entry.manifest == nil {
+			err = fmt.Errorf("镜像 %s manifest数据为空", imageRef)
+		}
 		if err != nil {
 			log.Printf("下载镜像 %s 失败: %v", imageRef, err)
-			return fmt.Errorf("下载镜像 %s 失败: %w", imageRef, err)
-		}
-
-		if manifest == nil {
-			return fmt.Errorf("镜像 %s manifest数据为空", imageRef)
+			if !options.SkipFailed {
+				return fmt.Errorf("下载镜像 %s 失败: %w", imageRef, err)
+			}
+			// 客户端断开或输出流已损坏时无法继续写入
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if flushErr := tarWriter.Flush(); flushErr != nil {
+				return fmt.Errorf("下载镜像 %s 失败且tar无法继续写入: %w", imageRef, flushErr)
+			}
+			report.Failed++
+			report.Images = append(report.Images, BatchImageReport{Image: imageRef, Status: batchStatusFailed, Error: err.Error()})
+			continue
 		}
 
-		allManifests = append(allManifests, manifest)
+		allManifests = append(allManifests, entry.manifest)
+		report.Succeeded++
+		report.Images = append(report.Images, BatchImageReport{
+			Image:    imageRef,
+			Status:   batchStatusSuccess,
+			Digest:   entry.digest,
+			Platform: entry.platform,
+			Size:     entry.size,
+		})
 
-		for repo, tags := range repositories {
+		for repo, tags := range entry.repositories {
 			if allRepositories[repo] == nil {
 				allRepositories[repo] = make(map[string]string)
 			}
@@ -1047,6 +1566,24 @@ func (is *ImageStreamer) StreamMultipleImages(ctx context.Context, imageRefs []s
 		}
 	}
 
+	if options.SkipFailed {
+		reportData, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("序列化下载报告失败: %w", err)
+		}
+		reportHeader := &tar.Header{
+			Name: batchReportFile,
+			Size: int64(len(reportData)),
+			Mode: 0644,
+		}
+		if err := tarWriter.WriteHeader(reportHeader); err != nil {
+			return fmt.Errorf("写入下载报告失败: %w", err)
+		}
+		if _, err := tarWriter.Write(reportData); err != nil {
+			return fmt.Errorf("写入下载报告失败: %w", err)
+		}
+	}
+
 	manifestData, err := json.Marshal(allManifests)
 	if err != nil {
 		return fmt.Errorf("序列化manifest失败: %w", err)
@@ -1085,6 +1622,7 @@ func (is *ImageStreamer) StreamMultipleImages(ctx context.Context, imageRefs []s
 		return fmt.Errorf("写入repositories数据失败: %w", err)
 	}
 
-	log.Printf("批量下载完成，共处理 %d 个镜像", len(imageRefs))
+	log.Printf("批量下载完成，共处理 %d 个镜像，成功 %d 个，失败 %d 个", len(imageRefs), report.Succeeded, report.Failed)
+	completed = true
 	return nil
 }
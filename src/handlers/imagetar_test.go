@@ -1,14 +1,32 @@
 package handlers
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/klauspost/compress/zstd"
+	"hubproxy/config"
+	"hubproxy/utils"
 )
 
 func TestDownloadDebouncer(t *testing.T) {
@@ -113,3 +131,382 @@ func TestWriteDownloadErrorSkipsJSONAfterBodyStarted(t *testing.T) {
 		}
 	})
 }
+
+func TestParseCompression(t *testing.T) {
+	tests := []struct {
+		value string
+		want  CompressionType
+	}{
+		{"", CompressionNone},
+		{"none", CompressionNone},
+		{"gzip", CompressionGzip},
+		{"ZSTD", CompressionZstd},
+	}
+	for _, tt := range tests {
+		got, err := parseCompression(tt.value)
+		if err != nil || got != tt.want {
+			t.Fatalf("parseCompression(%q) = %q, %v", tt.value, got, err)
+		}
+	}
+
+	if _, err := parseCompression("bzip2"); err == nil {
+		t.Fatal("unsupported compression accepted")
+	}
+	if got := CompressionZstd.archiveExtension(); got != ".tar.zst" {
+		t.Fatalf("zstd extension = %q", got)
+	}
+}
+
+func TestNewArchiveWriterRoundTrip(t *testing.T) {
+	payload := strings.Repeat("hubproxy-layer-data ", 4096)
+
+	for _, compression := range []CompressionType{CompressionNone, CompressionGzip, CompressionZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newArchiveWriter(&buf, compression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(w, payload); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			var r io.Reader = &buf
+			switch compression {
+			case CompressionGzip:
+				gz, err := gzip.NewReader(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r = gz
+			case CompressionZstd:
+				zr, err := zstd.NewReader(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer zr.Close()
+				r = zr
+			}
+
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != payload {
+				t.Fatalf("round trip mismatch: got %d bytes", len(got))
+			}
+		})
+	}
+}
+
+func TestSetDownloadHeadersUsesFileNotContentEncoding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	setDownloadHeaders(c, "nginx_latest"+CompressionGzip.archiveExtension(), CompressionGzip)
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("Content-Encoding = %q, want empty", got)
+	}
+	if got := w.Header().Get("Content-Disposition"); !strings.Contains(got, "nginx_latest.tar.gz") {
+		t.Fatalf("Content-Disposition = %q", got)
+	}
+}
+
+func TestRetagRulesResolve(t *testing.T) {
+	rules, err := NewRetagRules(map[string]string{
+		"nginx":              "harbor.local/base/nginx:1.27",
+		"ghcr.io/org/app:v1": "harbor.local:5000/apps/app",
+	}, "harbor.local/mirror/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"nginx:latest", "harbor.local/base/nginx:1.27"},
+		{"ghcr.io/org/app:v1", "harbor.local:5000/apps/app:v1"},
+		{"redis:7", "harbor.local/mirror/library/redis:7"},
+		{"quay.io/coreos/etcd:v3.5", "harbor.local/mirror/coreos/etcd:v3.5"},
+	}
+	for _, tt := range tests {
+		if got := rules.Resolve(tt.source); got != tt.want {
+			t.Fatalf("Resolve(%q) = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+
+	var empty *RetagRules
+	if got := empty.Resolve("nginx:latest"); got != "nginx:latest" {
+		t.Fatalf("nil rules changed ref: %q", got)
+	}
+	if _, err := NewRetagRules(nil, "Bad Prefix"); err == nil {
+		t.Fatal("invalid prefix accepted")
+	}
+}
+
+func TestStreamImageLayersWritesRetaggedManifest(t *testing.T) {
+	img, err := random.Image(256, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := NewRetagRules(nil, "harbor.local:5000/mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	streamer := &ImageStreamer{}
+	options := &StreamOptions{UseCompressedLayers: true, Retag: rules}
+	if err := streamer.streamImageLayers(context.Background(), img, &buf, options, "nginx:latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readTarFiles(t, &buf)
+	var manifest []struct {
+		RepoTags []string
+	}
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	want := "harbor.local:5000/mirror/library/nginx:latest"
+	if len(manifest) != 1 || len(manifest[0].RepoTags) != 1 || manifest[0].RepoTags[0] != want {
+		t.Fatalf("manifest = %#v", manifest)
+	}
+
+	var repositories map[string]map[string]string
+	if err := json.Unmarshal(files["repositories"], &repositories); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := repositories["harbor.local:5000/mirror/library/nginx"]["latest"]; !ok {
+		t.Fatalf("repositories = %#v", repositories)
+	}
+}
+
+func readTarFiles(t *testing.T, r io.Reader) map[string][]byte {
+	t.Helper()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = data
+	}
+}
+
+func loadTestConfig(t *testing.T, body string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	utils.InitHTTPClients()
+	InitDockerProxy()
+}
+
+// newTestRegistry 启动内存Registry并推送一个随机镜像，返回其地址
+func newTestRegistry(t *testing.T, repository string) (string, v1.Image) {
+	t.Helper()
+
+	srv := httptest.NewServer(registry.New())
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	img, err := random.Image(512, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference(host + "/" + repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatal(err)
+	}
+	return host, img
+}
+
+func TestStreamImageUsesRegistryMappingAndSharedCache(t *testing.T) {
+	host, img := newTestRegistry(t, "team/app:v1")
+	loadTestConfig(t, `
+[registries."mirror.local"]
+upstream = "`+host+`"
+enabled = true
+`)
+
+	var buf bytes.Buffer
+	streamer := &ImageStreamer{}
+	if err := streamer.StreamImageToWriter(context.Background(), "mirror.local/team/app:v1", &buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readTarFiles(t, &buf)
+	var manifest []struct {
+		RepoTags []string
+		Layers   []string
+	}
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != 1 || manifest[0].RepoTags[0] != "mirror.local/team/app:v1" || len(manifest[0].Layers) != 2 {
+		t.Fatalf("manifest = %#v", manifest)
+	}
+
+	if utils.GlobalCache.Get(utils.BuildManifestTagCacheKey(host+"/team/app", "v1")) == nil {
+		t.Fatal("manifest not stored in proxy cache")
+	}
+	configDigest, err := img.ConfigName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if utils.GlobalCache.Get(utils.BuildBlobCacheKey(host, configDigest.String())) == nil {
+		t.Fatal("config blob not stored in proxy cache")
+	}
+}
+
+func TestCheckImageAccessUsesRegistryMapping(t *testing.T) {
+	loadTestConfig(t, `
+[access]
+blackList = ["mirror.local/blocked/*"]
+
+[registries."mirror.local"]
+upstream = "127.0.0.1:1"
+enabled = true
+`)
+
+	if allowed, _ := checkImageAccess("mirror.local/blocked/app:v1"); allowed {
+		t.Fatal("blacklisted registry image allowed")
+	}
+	if allowed, reason := checkImageAccess("blocked/app:v1"); !allowed {
+		t.Fatalf("docker hub image denied: %s", reason)
+	}
+	if allowed, _ := checkImageAccess("unknown.example.com/app:v1"); allowed {
+		t.Fatal("unconfigured registry allowed")
+	}
+}
+
+func TestStreamMultipleImagesSkipFailedWritesReport(t *testing.T) {
+	host, img := newTestRegistry(t, "team/app:v1")
+	loadTestConfig(t, `
+[access]
+blackList = ["mirror.local/blocked/*"]
+
+[registries."mirror.local"]
+upstream = "`+host+`"
+enabled = true
+`)
+
+	images := []string{"mirror.local/team/app:v1", "mirror.local/team/missing:v1", "mirror.local/blocked/app:v1"}
+	streamer := &ImageStreamer{}
+
+	if err := streamer.StreamMultipleImages(context.Background(), images, io.Discard, &StreamOptions{}); err == nil {
+		t.Fatal("expected batch to fail without skipFailed")
+	}
+
+	var buf bytes.Buffer
+	if err := streamer.StreamMultipleImages(context.Background(), images, &buf, &StreamOptions{SkipFailed: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readTarFiles(t, &buf)
+	var manifest []struct{ RepoTags []string }
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != 1 || manifest[0].RepoTags[0] != images[0] {
+		t.Fatalf("manifest = %#v", manifest)
+	}
+
+	var report BatchReport
+	if err := json.Unmarshal(files[batchReportFile], &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 3 || report.Succeeded != 1 || report.Failed != 2 || len(report.Images) != 3 {
+		t.Fatalf("report = %#v", report)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := report.Images[0]
+	if ok.Status != batchStatusSuccess || ok.Digest != digest.String() || ok.Size <= 0 {
+		t.Fatalf("success entry = %#v", ok)
+	}
+	if report.Images[1].Status != batchStatusFailed || report.Images[1].Error == "" {
+		t.Fatalf("missing entry = %#v", report.Images[1])
+	}
+	if report.Images[2].Status != batchStatusDenied {
+		t.Fatalf("denied entry = %#v", report.Images[2])
+	}
+}
+
+func TestStreamMultipleImagesMissingPlatformFails(t *testing.T) {
+	srv := httptest.NewServer(registry.New())
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	img, err := random.Image(256, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{
+		Add: img,
+		Descriptor: v1.Descriptor{
+			Platform: &v1.Platform{OS: "linux", Architecture: "amd64"},
+		},
+	})
+	ref, err := name.ParseReference(host + "/team/multi:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.WriteIndex(ref, index); err != nil {
+		t.Fatal(err)
+	}
+	loadTestConfig(t, `
+[registries."mirror.local"]
+upstream = "`+host+`"
+enabled = true
+`)
+
+	images := []string{"mirror.local/team/multi:v1"}
+	var buf bytes.Buffer
+	streamer := &ImageStreamer{}
+	if err := streamer.StreamMultipleImages(context.Background(), images, &buf, &StreamOptions{Platform: "linux/arm64", SkipFailed: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readTarFiles(t, &buf)
+	var manifest []struct{ RepoTags []string }
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != 0 {
+		t.Fatalf("wrong-architecture image exported: %#v", manifest)
+	}
+	var report BatchReport
+	if err := json.Unmarshal(files[batchReportFile], &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Failed != 1 || report.Images[0].Status != batchStatusFailed || !strings.Contains(report.Images[0].Error, "linux/arm64") {
+		t.Fatalf("report = %#v", report)
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"hubproxy/config"
+	"hubproxy/utils"
+)
+
+const (
+	// authDiscoveryTTL 自动发现的认证端点缓存时间
+	authDiscoveryTTL = time.Hour
+	// authDiscoveryTimeout 探测上游 /v2/ 的超时时间
+	authDiscoveryTimeout = 10 * time.Second
+)
+
+// dockerHubHosts 始终走 Docker Hub 默认代理的域名，不参与透传
+var dockerHubHosts = map[string]bool{
+	"docker.io":            true,
+	"index.docker.io":      true,
+	"registry-1.docker.io": true,
+	"registry.docker.io":   true,
+}
+
+// discoveredAuth 从上游 WWW-Authenticate 发现的认证端点
+type discoveredAuth struct {
+	mapping   config.RegistryMapping
+	expiresAt time.Time
+}
+
+var (
+	authDiscoveryMu    sync.Mutex
+	authDiscoveryCache = make(map[string]discoveredAuth)
+)
+
+// passthroughAllowed 判断未在 [registries] 中配置的域名能否透传：需开启 [passthrough]，
+// 命中 allow 且未命中 deny；已配置（含已禁用）的 Registry 与 Docker Hub 不透传
+func passthroughAllowed(domain string) bool {
+	cfg := config.GetConfig()
+	if !cfg.Passthrough.Enabled {
+		return false
+	}
+
+	domain = strings.ToLower(domain)
+	if !strings.ContainsAny(domain, ".:") && domain != "localhost" {
+		return false
+	}
+	if dockerHubHosts[domain] {
+		return false
+	}
+	if _, configured := cfg.Registries[domain]; configured {
+		return false
+	}
+
+	return matchDomainPattern(domain, cfg.Passthrough.Allow) && !matchDomainPattern(domain, cfg.Passthrough.Deny)
+}
+
+// matchDomainPattern 按通配规则匹配域名，如 *.example.com、registry.example.com:5000、*
+func matchDomainPattern(domain string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if matched, err := path.Match(pattern, domain); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// passthroughMapping 透传域名使用的匿名映射
+func passthroughMapping(domain string) config.RegistryMapping {
+	return config.RegistryMapping{Upstream: domain, Enabled: true}
+}
+
+// discoverPassthroughAuth 探测上游 /v2/ 的 WWW-Authenticate，得到透传 Registry 的认证端点
+func discoverPassthroughAuth(domain string) (config.RegistryMapping, error) {
+	authDiscoveryMu.Lock()
+	cached, ok := authDiscoveryCache[domain]
+	authDiscoveryMu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.mapping, nil
+	}
+
+	registry, err := name.NewRegistry(domain)
+	if err != nil {
+		return config.RegistryMapping{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), authDiscoveryTimeout)
+	defer cancel()
+
+	challenge, err := transport.Ping(ctx, registry, utils.GetGlobalHTTPClient().Transport)
+	if err != nil {
+		return config.RegistryMapping{}, fmt.Errorf("探测 %s 认证方式失败: %w", domain, err)
+	}
+	realm, err := url.Parse(challenge.Parameters["realm"])
+	if err != nil || realm.Host == "" {
+		return config.RegistryMapping{}, fmt.Errorf("%s 未返回 Bearer 认证端点", domain)
+	}
+
+	mapping := passthroughMapping(domain)
+	mapping.AuthHost = realm.Host + realm.Path
+	if realm.Scheme == "http" {
+		mapping.Scheme = "http"
+	}
+
+	authDiscoveryMu.Lock()
+	authDiscoveryCache[domain] = discoveredAuth{mapping: mapping, expiresAt: time.Now().Add(authDiscoveryTTL)}
+	authDiscoveryMu.Unlock()
+	return mapping, nil
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPassthroughAllowList(t *testing.T) {
+	loadTestConfig(t, `
+[registries."ghcr.io"]
+upstream = "ghcr.io"
+enabled = false
+
+[passthrough]
+enabled = true
+allow = ["*.example.com", "ghcr.io"]
+deny = ["bad.example.com"]
+`)
+
+	tests := map[string]bool{
+		"registry.example.com": true,
+		"bad.example.com":      false,
+		"example.org":          false,
+		"ghcr.io":              false,
+		"docker.io":            false,
+		"library":              false,
+	}
+	for domain, want := range tests {
+		if got := passthroughAllowed(domain); got != want {
+			t.Fatalf("passthroughAllowed(%q) = %v, want %v", domain, got, want)
+		}
+	}
+}
+
+func TestPassthroughProxiesAndDiscoversAuth(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/tags/list") {
+			w.Write([]byte(`{"name":"team/app","tags":["v1"]}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+	host := strings.TrimPrefix(upstream.URL, "http://")
+
+	var realm string
+	secured := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`",service="registry.test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer secured.Close()
+	realm = secured.URL + "/auth/token"
+	securedHost := strings.TrimPrefix(secured.URL, "http://")
+
+	loadTestConfig(t, `
+[passthrough]
+enabled = true
+allow = ["127.0.0.1:*"]
+`)
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/v2/"+host+"/team/app/tags/list", nil)
+	handleRegistryRequest(c, c.Request.URL.Path)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"v1"`) {
+		t.Fatalf("passthrough tags: %d %s", w.Code, w.Body.String())
+	}
+
+	mapping, err := discoverPassthroughAuth(securedHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapping.AuthHost != securedHost+"/auth/token" || mapping.Scheme != "http" {
+		t.Fatalf("discovered mapping = %+v", mapping)
+	}
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/token?service="+securedHost+"&scope=repository:team/app:pull", nil)
+	if got, want := buildDockerAuthURL(c), "http://"+securedHost+"/auth/token?service="+securedHost+"&scope=repository:team/app:pull"; got != want {
+		t.Fatalf("auth url = %q, want %q", got, want)
+	}
+}
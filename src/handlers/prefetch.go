@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"hubproxy/config"
+	"hubproxy/utils"
+)
+
+const (
+	// maxPrefetchImages 单个预热任务的镜像数上限
+	maxPrefetchImages = 200
+	// maxPrefetchJobs 保留的任务记录数，超出后丢弃最早完成的任务
+	maxPrefetchJobs = 50
+	// prefetchConcurrency 单个任务内并发预热的镜像数
+	prefetchConcurrency = 4
+	// prefetchImageTimeout 单个镜像的预热超时
+	prefetchImageTimeout = 5 * time.Minute
+)
+
+// 预热任务与镜像状态
+const (
+	prefetchStatusPending   = "pending"
+	prefetchStatusRunning   = "running"
+	prefetchStatusCompleted = "completed"
+)
+
+// 预热任务来源
+const (
+	prefetchSourceAPI    = "api"
+	prefetchSourceConfig = "config"
+)
+
+// PrefetchImageStatus 单个镜像的预热结果
+type PrefetchImageStatus struct {
+	Image     string `json:"image"`
+	Status    string `json:"status"`
+	Digest    string `json:"digest,omitempty"`
+	Manifests int    `json:"manifests,omitempty"`
+	Blobs     int    `json:"blobs,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// PrefetchJob 预热任务
+type PrefetchJob struct {
+	ID         string                `json:"id"`
+	Source     string                `json:"source"`
+	Status     string                `json:"status"`
+	Platforms  []string              `json:"platforms,omitempty"`
+	CreatedAt  time.Time             `json:"created_at"`
+	FinishedAt *time.Time            `json:"finished_at,omitempty"`
+	Total      int                   `json:"total"`
+	Succeeded  int                   `json:"succeeded"`
+	Failed     int                   `json:"failed"`
+	Images     []PrefetchImageStatus `json:"images"`
+}
+
+// prefetchJobStore 内存中的预热任务记录
+type prefetchJobStore struct {
+	mu    sync.RWMutex
+	jobs  map[string]*PrefetchJob
+	order []string
+}
+
+var prefetchJobs = &prefetchJobStore{jobs: make(map[string]*PrefetchJob)}
+
+// create 登记新任务，记录过多时丢弃最早完成的任务
+func (s *prefetchJobStore) create(source string, images, platforms []string) (*PrefetchJob, error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+
+	job := &PrefetchJob{
+		ID:        hex.EncodeToString(idBytes),
+		Source:    source,
+		Status:    prefetchStatusPending,
+		Platforms: platforms,
+		CreatedAt: time.Now(),
+		Total:     len(images),
+		Images:    make([]PrefetchImageStatus, len(images)),
+	}
+	for i, image := range images {
+		job.Images[i] = PrefetchImageStatus{Image: image, Status: prefetchStatusPending}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.order) >= maxPrefetchJobs {
+		evicted := false
+		for i, id := range s.order {
+			if s.jobs[id].FinishedAt != nil {
+				delete(s.jobs, id)
+				s.order = append(s.order[:i], s.order[i+1:]...)
+				evicted = true
+				break
+			}
+		}
+		if !evicted {
+			return nil, fmt.Errorf("进行中的预热任务过多，请稍后再试")
+		}
+	}
+	s.jobs[job.ID] = job
+	s.order = append(s.order, job.ID)
+	return job, nil
+}
+
+// snapshot 返回任务副本，避免序列化时与后台更新竞争
+func (s *prefetchJobStore) snapshot(id string) (PrefetchJob, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return PrefetchJob{}, false
+	}
+	copied := *job
+	copied.Images = append([]PrefetchImageStatus(nil), job.Images...)
+	return copied, true
+}
+
+// list 按创建时间倒序返回任务概要（不含镜像明细）
+func (s *prefetchJobStore) list() []PrefetchJob {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	jobs := make([]PrefetchJob, 0, len(s.order))
+	for i := len(s.order) - 1; i >= 0; i-- {
+		copied := *s.jobs[s.order[i]]
+		copied.Images = nil
+		jobs = append(jobs, copied)
+	}
+	return jobs
+}
+
+func (s *prefetchJobStore) update(job *PrefetchJob, fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn()
+	if job.Succeeded+job.Failed == job.Total && job.FinishedAt == nil {
+		now := time.Now()
+		job.FinishedAt = &now
+		job.Status = prefetchStatusCompleted
+	}
+}
+
+// StartPrefetch 登记并在后台执行预热任务，镜像经与 /v2 代理相同的映射与缓存解析
+func StartPrefetch(source string, images, platforms []string) (*PrefetchJob, error) {
+	job, err := prefetchJobs.create(source, images, platforms)
+	if err != nil {
+		return nil, err
+	}
+	go runPrefetchJob(job, images, platforms)
+	return job, nil
+}
+
+// StartConfiguredPrefetch 启动时预热 [prefetch].images 中的镜像
+func StartConfiguredPrefetch() {
+	cfg := config.GetConfig()
+	if len(cfg.Prefetch.Images) == 0 {
+		return
+	}
+	if !utils.IsCacheEnabled() {
+		log.Printf("缓存未启用，跳过配置的镜像预热")
+		return
+	}
+	if err := validatePrefetchPlatforms(cfg.Prefetch.Platforms); err != nil {
+		log.Printf("镜像预热配置无效: %v", err)
+		return
+	}
+	job, err := StartPrefetch(prefetchSourceConfig, cfg.Prefetch.Images, cfg.Prefetch.Platforms)
+	if err != nil {
+		log.Printf("启动镜像预热失败: %v", err)
+		return
+	}
+	log.Printf("开始预热 %d 个镜像 (任务: %s)", job.Total, job.ID)
+}
+
+func runPrefetchJob(job *PrefetchJob, images, platforms []string) {
+	prefetchJobs.update(job, func() { job.Status = prefetchStatusRunning })
+
+	sem := make(chan struct{}, prefetchConcurrency)
+	var wg sync.WaitGroup
+	for i, image := range images {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, image string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			prefetchJobs.update(job, func() { job.Images[i].Status = prefetchStatusRunning })
+			ctx, cancel := context.WithTimeout(utils.BackgroundContext(), prefetchImageTimeout)
+			result := prefetchImage(ctx, image, platforms)
+			cancel()
+
+			prefetchJobs.update(job, func() {
+				job.Images[i] = result
+				if result.Status == batchStatusSuccess {
+					job.Succeeded++
+				} else {
+					job.Failed++
+				}
+			})
+		}(i, image)
+	}
+	wg.Wait()
+
+	log.Printf("镜像预热完成 (任务: %s): 成功 %d，失败 %d", job.ID, job.Succeeded, job.Failed)
+}
+
+// prefetchImage 拉取镜像 manifest（多架构时包括所选平台的子 manifest）及镜像config并写入共享缓存
+func prefetchImage(ctx context.Context, image string, platforms []string) PrefetchImageStatus {
+	result := PrefetchImageStatus{Image: image, Status: batchStatusFailed}
+	image = expandRegistryAlias(image)
+
+	if allowed, reason := checkImageAccess(image); !allowed {
+		result.Status = batchStatusDenied
+		result.Error = reason
+		return result
+	}
+
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	target, err := resolveUpstreamTarget(ref.Context())
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	imageRef := target.repository.Name()
+	manifest, err := fetchManifest(ctx, imageRef, ref.Identifier(), nil, target.options)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Digest = manifest.Headers["Docker-Content-Digest"]
+	result.Manifests++
+
+	mediaType := types.MediaType(manifest.ContentType)
+	if mediaType != types.OCIImageIndex && mediaType != types.DockerManifestList {
+		if err := prefetchConfig(ctx, target, manifest); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Blobs++
+		result.Status = batchStatusSuccess
+		return result
+	}
+
+	index, err := v1.ParseIndexManifest(bytes.NewReader(manifest.Data))
+	if err != nil {
+		result.Error = fmt.Sprintf("解析镜像索引失败: %v", err)
+		return result
+	}
+	for _, desc := range index.Manifests {
+		if !matchesPrefetchPlatform(desc.Platform, platforms) {
+			continue
+		}
+		child, err := fetchManifest(ctx, imageRef, desc.Digest.String(), nil, target.options)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Manifests++
+		if err := prefetchConfig(ctx, target, child); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Blobs++
+	}
+
+	result.Status = batchStatusSuccess
+	return result
+}
+
+// prefetchConfig 将镜像config写入共享 blob 缓存，与 /v2 代理的小 blob 缓存一致
+func prefetchConfig(ctx context.Context, target *upstreamTarget, manifest *utils.CachedItem) error {
+	img, err := newCachedImage(ctx, target, manifest)
+	if err != nil {
+		return err
+	}
+	_, err = img.RawConfigFile()
+	return err
+}
+
+// matchesPrefetchPlatform 判断索引条目是否属于所选平台，platforms 为空时预热全部平台
+func matchesPrefetchPlatform(platform *v1.Platform, platforms []string) bool {
+	if len(platforms) == 0 {
+		return true
+	}
+	if platform == nil {
+		return false
+	}
+	for _, p := range platforms {
+		parts := strings.Split(p, "/")
+		if len(parts) < 2 || platform.OS != parts[0] || platform.Architecture != parts[1] {
+			continue
+		}
+		if len(parts) < 3 || platform.Variant == parts[2] {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePrefetchPlatforms 校验平台格式 os/arch[/variant]
+func validatePrefetchPlatforms(platforms []string) error {
+	for _, p := range platforms {
+		parts := strings.Split(p, "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("平台格式错误: %s，应为 os/arch[/variant]", p)
+		}
+	}
+	return nil
+}
+
+// RegisterPrefetchRoutes 注册预热接口，需要管理令牌
+func RegisterPrefetchRoutes(router *gin.Engine) {
+	prefetchAPI := router.Group("/api/cache/prefetch", utils.AdminAuthMiddleware())
+	{
+		prefetchAPI.POST("", handlePrefetchCreate)
+		prefetchAPI.GET("", handlePrefetchList)
+		prefetchAPI.GET("/:id", handlePrefetchStatus)
+	}
+}
+
+// handlePrefetchCreate 创建预热任务，立即返回任务ID
+func handlePrefetchCreate(c *gin.Context) {
+	var req struct {
+		Images    []string `json:"images"`
+		Platforms []string `json:"platforms"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误: " + err.Error()})
+		return
+	}
+
+	if !utils.IsCacheEnabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缓存未启用，无法预热"})
+		return
+	}
+
+	var images []string
+	seen := make(map[string]bool)
+	for _, image := range req.Images {
+		image = strings.TrimSpace(image)
+		if image == "" || seen[image] {
+			continue
+		}
+		seen[image] = true
+		images = append(images, image)
+	}
+	if len(images) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "镜像列表不能为空"})
+		return
+	}
+	if len(images) > maxPrefetchImages {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("单次最多预热 %d 个镜像", maxPrefetchImages)})
+		return
+	}
+	if err := validatePrefetchPlatforms(req.Platforms); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	job, err := StartPrefetch(prefetchSourceAPI, images, req.Platforms)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"id":         job.ID,
+		"total":      job.Total,
+		"status_url": "/api/cache/prefetch/" + job.ID,
+	})
+}
+
+// handlePrefetchList 列出最近的预热任务
+func handlePrefetchList(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"jobs": prefetchJobs.list()})
+}
+
+// handlePrefetchStatus 查询预热任务状态
+func handlePrefetchStatus(c *gin.Context) {
+	job, ok := prefetchJobs.snapshot(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "预热任务不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"hubproxy/utils"
+)
+
+func TestMatchesPrefetchPlatform(t *testing.T) {
+	amd64 := &v1.Platform{OS: "linux", Architecture: "amd64"}
+	armv7 := &v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}
+
+	if !matchesPrefetchPlatform(amd64, nil) || !matchesPrefetchPlatform(nil, nil) {
+		t.Fatal("empty platform list should match everything")
+	}
+	if !matchesPrefetchPlatform(amd64, []string{"linux/arm64", "linux/amd64"}) {
+		t.Fatal("linux/amd64 not matched")
+	}
+	if !matchesPrefetchPlatform(armv7, []string{"linux/arm"}) || matchesPrefetchPlatform(armv7, []string{"linux/arm/v6"}) {
+		t.Fatal("variant matching wrong")
+	}
+	if matchesPrefetchPlatform(nil, []string{"linux/amd64"}) {
+		t.Fatal("entry without platform matched a filter")
+	}
+	if err := validatePrefetchPlatforms([]string{"linux"}); err == nil {
+		t.Fatal("expected invalid platform error")
+	}
+}
+
+func TestPrefetchPopulatesSharedCache(t *testing.T) {
+	host, img := newTestRegistry(t, "team/app:v1")
+	loadTestConfig(t, `
+[access]
+blackList = ["mirror.local/blocked/*"]
+
+[registries."mirror.local"]
+upstream = "`+host+`"
+enabled = true
+`)
+
+	job, err := StartPrefetch(prefetchSourceAPI, []string{"mirror.local/team/app:v1", "mirror.local/blocked/app:v1"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var status PrefetchJob
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		status, _ = prefetchJobs.snapshot(job.ID)
+		if status.Status == prefetchStatusCompleted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("prefetch did not finish: %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if status.Succeeded != 1 || status.Failed != 1 {
+		t.Fatalf("job = %+v", status)
+	}
+	if status.Images[0].Status != batchStatusSuccess || status.Images[1].Status != batchStatusDenied {
+		t.Fatalf("images = %+v", status.Images)
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Images[0].Digest != digest.String() {
+		t.Fatalf("digest = %s, want %s", status.Images[0].Digest, digest)
+	}
+	if item, fresh := lookupCachedManifest(host+"/team/app", "v1", nil); item == nil || !fresh {
+		t.Fatal("manifest not cached")
+	}
+	configDigest, err := img.ConfigName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if utils.GlobalCache.Get(utils.BuildBlobCacheKey(host, configDigest.String())) == nil {
+		t.Fatal("config blob not cached")
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"hubproxy/config"
+)
+
+// rewritePatterns 已编译的改写规则正则，按表达式缓存
+var rewritePatterns sync.Map
+
+// compileRewritePattern 编译并缓存改写规则的正则
+func compileRewritePattern(pattern string) (*regexp.Regexp, error) {
+	if cached, ok := rewritePatterns.Load(pattern); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("改写规则 %q 无效: %w", pattern, err)
+	}
+	rewritePatterns.Store(pattern, re)
+	return re, nil
+}
+
+// upstreamRepository 按映射的改写规则转换客户端请求的仓库名。
+// 依次去除 stripPrefix、为单段名称补全 defaultNamespace、应用第一条匹配的 rewrite 规则，得到用于访问控制的规范名；
+// 再加上 pathPrefix 与上游地址，得到实际拉取的镜像引用
+func upstreamRepository(mapping config.RegistryMapping, repository string) (normalized, upstreamRef string, err error) {
+	normalized = strings.Trim(repository, "/")
+
+	if prefix := strings.Trim(mapping.StripPrefix, "/"); prefix != "" {
+		if rest, ok := strings.CutPrefix(normalized, prefix+"/"); ok {
+			normalized = rest
+		}
+	}
+
+	if ns := strings.Trim(mapping.DefaultNamespace, "/"); ns != "" && !strings.Contains(normalized, "/") {
+		normalized = ns + "/" + normalized
+	}
+
+	for _, rule := range mapping.Rewrite {
+		re, err := compileRewritePattern(rule.Match)
+		if err != nil {
+			return "", "", err
+		}
+		if re.MatchString(normalized) {
+			normalized = strings.Trim(re.ReplaceAllString(normalized, rule.Replace), "/")
+			break
+		}
+	}
+
+	if normalized == "" {
+		return "", "", fmt.Errorf("仓库名 %q 改写后为空", repository)
+	}
+
+	upstreamPath := normalized
+	if prefix := strings.Trim(mapping.PathPrefix, "/"); prefix != "" {
+		upstreamPath = prefix + "/" + normalized
+	}
+	return normalized, mapping.Upstream + "/" + upstreamPath, nil
+}
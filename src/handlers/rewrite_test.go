@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"hubproxy/config"
+)
+
+func TestUpstreamRepository(t *testing.T) {
+	mapping := config.RegistryMapping{
+		Upstream:         "harbor.local",
+		StripPrefix:      "mirror",
+		DefaultNamespace: "library",
+		Rewrite: []config.RewriteRule{
+			{Match: `^bitnami/(.+)$`, Replace: "bitnamicharts/$1"},
+			{Match: `^bitnamicharts/.+$`, Replace: "never-applied"},
+		},
+		PathPrefix: "/dockerhub-proxy/",
+	}
+
+	tests := []struct {
+		repository string
+		normalized string
+		upstream   string
+	}{
+		{"nginx", "library/nginx", "harbor.local/dockerhub-proxy/library/nginx"},
+		{"mirror/nginx", "library/nginx", "harbor.local/dockerhub-proxy/library/nginx"},
+		{"team/app", "team/app", "harbor.local/dockerhub-proxy/team/app"},
+		{"bitnami/redis", "bitnamicharts/redis", "harbor.local/dockerhub-proxy/bitnamicharts/redis"},
+	}
+	for _, tt := range tests {
+		normalized, upstream, err := upstreamRepository(mapping, tt.repository)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.repository, err)
+		}
+		if normalized != tt.normalized || upstream != tt.upstream {
+			t.Fatalf("%s: got (%q, %q), want (%q, %q)", tt.repository, normalized, upstream, tt.normalized, tt.upstream)
+		}
+	}
+
+	if _, _, err := upstreamRepository(config.RegistryMapping{Rewrite: []config.RewriteRule{{Match: "("}}}, "app"); err == nil {
+		t.Fatal("invalid pattern should fail")
+	}
+}
+
+func TestMultiRegistryRequestAppliesRewrite(t *testing.T) {
+	var lastPath atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/tags/list") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		lastPath.Store(r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name":"dockerhub-proxy/library/nginx","tags":["latest"]}`))
+	}))
+	defer upstream.Close()
+
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	loadTestConfig(t, `
+[access]
+blackList = ["harbor.local/library/redis"]
+
+[registries."harbor.local"]
+upstream = "`+host+`"
+enabled = true
+defaultNamespace = "library"
+pathPrefix = "dockerhub-proxy"
+`)
+	gin.SetMode(gin.TestMode)
+
+	request := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/v2/harbor.local/"+path, nil)
+		handleMultiRegistryRequest(c, "harbor.local", path)
+		return w
+	}
+
+	w := request("nginx/tags/list")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	if got := lastPath.Load(); got != "/v2/dockerhub-proxy/library/nginx/tags/list" {
+		t.Fatalf("upstream path = %v", got)
+	}
+	if !strings.Contains(w.Body.String(), `"name":"nginx"`) {
+		t.Fatalf("tags name not client-facing: %s", w.Body.String())
+	}
+
+	if w := request("redis/tags/list"); w.Code != http.StatusForbidden {
+		t.Fatalf("blacklisted rewritten name: status = %d", w.Code)
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -72,47 +71,42 @@ type TagPageResult struct {
 	HasMore bool      `json:"has_more"`
 }
 
-type cacheEntry struct {
-	data      interface{}
-	expiresAt time.Time
-}
-
 const (
-	maxCacheSize       = 1000
-	maxPaginationCache = 200
-	cacheTTL           = 30 * time.Minute
+	cacheTTL = 30 * time.Minute
+	// defaultSearchEntrySize 无法序列化估算时的条目大小
+	defaultSearchEntrySize = 4 << 10
 )
 
+// Cache 搜索与标签结果缓存，存放在共享 LRU 缓存的 search / tags 命名空间
 type Cache struct {
-	data    map[string]cacheEntry
-	mu      sync.RWMutex
-	maxSize int
+	store *utils.LRUCache
 }
 
 var (
-	searchCache = &Cache{
-		data:    make(map[string]cacheEntry),
-		maxSize: maxCacheSize,
-	}
+	searchCache = &Cache{store: utils.SharedCache}
 )
 
-func (c *Cache) Get(key string) (interface{}, bool) {
-	c.mu.RLock()
-	entry, exists := c.data[key]
-	c.mu.RUnlock()
+// searchNamespace 按 key 前缀区分搜索结果与标签分页
+func searchNamespace(key string) string {
+	if strings.HasPrefix(key, "tags:") {
+		return utils.CacheNamespaceTags
+	}
+	return utils.CacheNamespaceSearch
+}
 
-	if !exists {
+func (c *Cache) Get(key string) (interface{}, bool) {
+	ns := searchNamespace(key)
+	data, expiresAt, ok := c.store.Get(ns, key)
+	if !ok {
 		return nil, false
 	}
 
-	if time.Now().After(entry.expiresAt) {
-		c.mu.Lock()
-		delete(c.data, key)
-		c.mu.Unlock()
+	if time.Now().After(expiresAt) {
+		c.store.Delete(ns, key)
 		return nil, false
 	}
 
-	return entry.data, true
+	return data, true
 }
 
 func (c *Cache) Set(key string, data interface{}) {
@@ -120,32 +114,15 @@ func (c *Cache) Set(key string, data interface{}) {
 }
 
 func (c *Cache) SetWithTTL(key string, data interface{}, ttl time.Duration) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if len(c.data) >= c.maxSize {
-		c.cleanupExpiredLocked()
-	}
-
-	c.data[key] = cacheEntry{
-		data:      data,
-		expiresAt: time.Now().Add(ttl),
+	size := int64(defaultSearchEntrySize)
+	if encoded, err := json.Marshal(data); err == nil {
+		size = int64(len(encoded))
 	}
+	c.store.Set(searchNamespace(key), key, data, size, ttl)
 }
 
 func (c *Cache) Cleanup() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.cleanupExpiredLocked()
-}
-
-func (c *Cache) cleanupExpiredLocked() {
-	now := time.Now()
-	for key, entry := range c.data {
-		if now.After(entry.expiresAt) {
-			delete(c.data, key)
-		}
-	}
+	c.store.RemoveExpired(0, utils.CacheNamespaceSearch, utils.CacheNamespaceTags)
 }
 
 func init() {
@@ -153,8 +130,13 @@ func init() {
 		ticker := time.NewTicker(5 * time.Minute)
 		defer ticker.Stop()
 
-		for range ticker.C {
-			searchCache.Cleanup()
+		for {
+			select {
+			case <-ticker.C:
+				searchCache.Cleanup()
+			case <-utils.BackgroundContext().Done():
+				return
+			}
 		}
 	}()
 }
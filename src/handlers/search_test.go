@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"hubproxy/utils"
 )
 
 func TestNormalizeRepository(t *testing.T) {
@@ -36,7 +37,7 @@ func TestParsePaginationParams(t *testing.T) {
 }
 
 func TestSearchCacheExpires(t *testing.T) {
-	cache := &Cache{data: make(map[string]cacheEntry), maxSize: 10}
+	cache := &Cache{store: utils.NewLRUCache(1<<20, nil)}
 	cache.SetWithTTL("k", "v", -time.Second)
 
 	if got, ok := cache.Get("k"); ok || got != nil {
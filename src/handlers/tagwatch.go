@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	"hubproxy/config"
+	"hubproxy/utils"
+)
+
+// TagChange tag 指向的 digest 变化记录
+type TagChange struct {
+	Digest         string    `json:"digest"`
+	PreviousDigest string    `json:"previous_digest,omitempty"`
+	DetectedAt     time.Time `json:"detected_at"`
+}
+
+// WatchedTag 被监视 tag 的当前状态与变更历史
+type WatchedTag struct {
+	Image       string      `json:"image"`
+	Digest      string      `json:"digest,omitempty"`
+	LastChecked *time.Time  `json:"last_checked,omitempty"`
+	LastError   string      `json:"last_error,omitempty"`
+	History     []TagChange `json:"history,omitempty"`
+}
+
+// tagWatcher 定时重新解析可变 tag，发现 digest 变化时刷新缓存并记录历史
+type tagWatcher struct {
+	mu      sync.RWMutex
+	tags    map[string]*WatchedTag
+	running sync.Mutex
+}
+
+var globalTagWatcher = &tagWatcher{tags: make(map[string]*WatchedTag)}
+
+// watchKey 将镜像引用规范化为 tag 全名，digest 引用不可变，不支持监视
+func watchKey(image string) (name.Tag, error) {
+	return name.NewTag(expandRegistryAlias(strings.TrimSpace(image)))
+}
+
+// StartTagWatcher 按 [watch].schedule 定时刷新 [watch].images，启动时先执行一次
+func StartTagWatcher() {
+	cfg := config.GetConfig()
+	if len(cfg.Watch.Images) == 0 {
+		return
+	}
+
+	schedule, err := utils.ParseSchedule(cfg.Watch.Schedule)
+	if err != nil {
+		log.Printf("tag 监视计划无效: %v", err)
+		return
+	}
+	if err := validatePrefetchPlatforms(cfg.Watch.Platforms); err != nil {
+		log.Printf("tag 监视配置无效: %v", err)
+		return
+	}
+
+	globalTagWatcher.mu.Lock()
+	for _, image := range cfg.Watch.Images {
+		tag, err := watchKey(image)
+		if err != nil {
+			log.Printf("跳过无法监视的镜像 %s: %v", image, err)
+			continue
+		}
+		if _, exists := globalTagWatcher.tags[tag.Name()]; !exists {
+			globalTagWatcher.tags[tag.Name()] = &WatchedTag{Image: image}
+		}
+	}
+	globalTagWatcher.mu.Unlock()
+
+	log.Printf("tag 监视已启用: %d 个镜像，计划 %s", len(cfg.Watch.Images), cfg.Watch.Schedule)
+	go func() {
+		globalTagWatcher.sync()
+		for {
+			next := schedule.Next(time.Now())
+			if next.IsZero() {
+				log.Printf("tag 监视计划 %s 不会再触发", cfg.Watch.Schedule)
+				return
+			}
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-timer.C:
+			case <-utils.BackgroundContext().Done():
+				timer.Stop()
+				return
+			}
+			globalTagWatcher.sync()
+		}
+	}()
+}
+
+// sync 刷新所有监视中的 tag，上一轮未结束或离线模式时跳过
+func (w *tagWatcher) sync() {
+	if !w.running.TryLock() {
+		log.Printf("上一轮 tag 监视尚未结束，跳过本轮")
+		return
+	}
+	defer w.running.Unlock()
+
+	if utils.IsOfflineMode() || !utils.IsCacheEnabled() {
+		return
+	}
+
+	cfg := config.GetConfig()
+	for _, image := range cfg.Watch.Images {
+		ctx, cancel := context.WithTimeout(utils.BackgroundContext(), prefetchImageTimeout)
+		w.checkTag(ctx, image, cfg.Watch.Platforms, cfg.Watch.HistorySize)
+		cancel()
+	}
+}
+
+// checkTag 使 tag 索引过期后经 fetchManifest 重新解析：未变化时上游返回 304 续期，变化时缓存新的
+// manifest 与镜像config；返回 digest 是否发生变化
+func (w *tagWatcher) checkTag(ctx context.Context, image string, platforms []string, historySize int) bool {
+	tag, err := watchKey(image)
+	if err != nil {
+		log.Printf("跳过无法监视的镜像 %s: %v", image, err)
+		return false
+	}
+
+	if target, err := resolveUpstreamTarget(tag.Context()); err == nil {
+		utils.GlobalCache.Expire(utils.BuildManifestTagCacheKey(target.repository.Name(), tag.TagStr()))
+	}
+
+	result := prefetchImage(ctx, image, platforms)
+	return w.record(tag.Name(), image, result, historySize)
+}
+
+// record 更新 tag 状态，digest 变化时追加历史并只保留最近 historySize 条
+func (w *tagWatcher) record(key, image string, result PrefetchImageStatus, historySize int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	entry, exists := w.tags[key]
+	if !exists {
+		entry = &WatchedTag{Image: image}
+		w.tags[key] = entry
+	}
+	now := time.Now()
+	entry.LastChecked = &now
+
+	if result.Status != batchStatusSuccess {
+		entry.LastError = result.Error
+		log.Printf("tag 监视 %s 失败: %s", image, result.Error)
+		return false
+	}
+	entry.LastError = ""
+	if result.Digest == entry.Digest {
+		return false
+	}
+
+	previous := entry.Digest
+	entry.History = append(entry.History, TagChange{
+		Digest:         result.Digest,
+		PreviousDigest: previous,
+		DetectedAt:     now,
+	})
+	if historySize > 0 && len(entry.History) > historySize {
+		entry.History = append([]TagChange(nil), entry.History[len(entry.History)-historySize:]...)
+	}
+	entry.Digest = result.Digest
+
+	if previous != "" {
+		log.Printf("tag %s digest 已变化: %s -> %s", image, previous, result.Digest)
+		utils.EmitEvent(utils.EventTagChanged, gin.H{
+			"image":           image,
+			"digest":          result.Digest,
+			"previous_digest": previous,
+		})
+	}
+	return previous != ""
+}
+
+// list 返回所有监视中的 tag 状态（不含历史）
+func (w *tagWatcher) list() []WatchedTag {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	tags := make([]WatchedTag, 0, len(w.tags))
+	for _, entry := range w.tags {
+		copied := *entry
+		copied.History = nil
+		tags = append(tags, copied)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Image < tags[j].Image })
+	return tags
+}
+
+// history 返回指定 tag 的状态与变更历史
+func (w *tagWatcher) history(image string) (WatchedTag, bool) {
+	tag, err := watchKey(image)
+	if err != nil {
+		return WatchedTag{}, false
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	entry, ok := w.tags[tag.Name()]
+	if !ok {
+		return WatchedTag{}, false
+	}
+	copied := *entry
+	copied.History = append([]TagChange(nil), entry.History...)
+	return copied, true
+}
+
+// RegisterTagWatchRoutes 注册 tag 监视查询接口，需要管理令牌
+func RegisterTagWatchRoutes(router *gin.Engine) {
+	watchAPI := router.Group("/api/watch", utils.AdminAuthMiddleware())
+	{
+		watchAPI.GET("", handleWatchList)
+		watchAPI.GET("/history", handleWatchHistory)
+		watchAPI.POST("/sync", handleWatchSync)
+	}
+}
+
+// handleWatchList 列出监视中的 tag 及当前 digest
+func handleWatchList(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"schedule": config.GetConfig().Watch.Schedule,
+		"tags":     globalTagWatcher.list(),
+	})
+}
+
+// handleWatchHistory 查询 tag 的 digest 变更历史
+func handleWatchHistory(c *gin.Context) {
+	image := strings.TrimSpace(c.Query("image"))
+	if image == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少镜像参数"})
+		return
+	}
+	entry, ok := globalTagWatcher.history(image)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "该镜像未被监视"})
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+}
+
+// handleWatchSync 立即在后台执行一轮刷新
+func handleWatchSync(c *gin.Context) {
+	if len(config.GetConfig().Watch.Images) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未配置监视的镜像"})
+		return
+	}
+	go globalTagWatcher.sync()
+	c.JSON(http.StatusAccepted, gin.H{"status": "started"})
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestTagWatcherRecordsDigestChanges(t *testing.T) {
+	host, img := newTestRegistry(t, "team/app:main")
+	loadTestConfig(t, `
+[registries."mirror.local"]
+upstream = "`+host+`"
+enabled = true
+`)
+
+	w := &tagWatcher{tags: make(map[string]*WatchedTag)}
+	ctx := context.Background()
+	if w.checkTag(ctx, "mirror.local/team/app:main", nil, 2) {
+		t.Fatal("first resolution reported as a change")
+	}
+
+	// tag 被推送新镜像后应检测到变化并刷新缓存
+	updated, err := random.Image(256, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference(host + "/team/app:main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, updated); err != nil {
+		t.Fatal(err)
+	}
+	if !w.checkTag(ctx, "mirror.local/team/app:main", nil, 2) {
+		t.Fatal("digest change not detected")
+	}
+	if w.checkTag(ctx, "mirror.local/team/app:main", nil, 2) {
+		t.Fatal("unchanged tag reported as a change")
+	}
+
+	oldDigest, _ := img.Digest()
+	newDigest, _ := updated.Digest()
+	entry, ok := w.history("mirror.local/team/app:main")
+	if !ok {
+		t.Fatal("history missing")
+	}
+	if entry.Digest != newDigest.String() || len(entry.History) != 2 {
+		t.Fatalf("entry = %+v", entry)
+	}
+	if entry.History[1].PreviousDigest != oldDigest.String() || entry.History[1].Digest != newDigest.String() {
+		t.Fatalf("history = %+v", entry.History)
+	}
+
+	cached, fresh := lookupCachedManifest(host+"/team/app", "main", nil)
+	if cached == nil || !fresh || cached.Headers["Docker-Content-Digest"] != newDigest.String() {
+		t.Fatal("cache not refreshed to the new digest")
+	}
+
+	if _, ok := w.history("mirror.local/team/app@sha256:" + newDigest.Hex); ok {
+		t.Fatal("digest reference treated as watched tag")
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"hubproxy/utils"
+)
+
+// upstreamFailureThreshold 连续失败达到该次数时认为上游故障
+const upstreamFailureThreshold = 3
+
+// upstreamHealth 按上游主机统计连续失败次数，状态变化时发出 webhook 事件
+type upstreamHealth struct {
+	mu       sync.Mutex
+	failures map[string]int
+	failing  map[string]bool
+}
+
+var upstreamHealthTracker = &upstreamHealth{
+	failures: make(map[string]int),
+	failing:  make(map[string]bool),
+}
+
+// observe 记录一次上游请求结果：网络错误、5xx、429 计为失败，客户端取消不计入
+func (h *upstreamHealth) observe(req *http.Request, resp *http.Response, err error) {
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(req.Context().Err(), context.Canceled) {
+			return
+		}
+		h.record(req.URL.Host, err.Error())
+		return
+	}
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+		h.record(req.URL.Host, http.StatusText(resp.StatusCode))
+		return
+	}
+	h.record(req.URL.Host, "")
+}
+
+// record failure 为空表示请求成功
+func (h *upstreamHealth) record(host, failure string) {
+	h.mu.Lock()
+	if failure == "" {
+		wasFailing := h.failing[host]
+		delete(h.failures, host)
+		delete(h.failing, host)
+		h.mu.Unlock()
+		if wasFailing {
+			log.Printf("上游 %s 已恢复", host)
+			utils.EmitEvent(utils.EventUpstreamRecovered, gin.H{"registry": host})
+		}
+		return
+	}
+
+	h.failures[host]++
+	failures := h.failures[host]
+	becameFailing := failures >= upstreamFailureThreshold && !h.failing[host]
+	if becameFailing {
+		h.failing[host] = true
+	}
+	h.mu.Unlock()
+
+	if becameFailing {
+		log.Printf("上游 %s 连续 %d 次请求失败: %s", host, failures, failure)
+		utils.EmitEvent(utils.EventUpstreamFailing, gin.H{
+			"registry": host,
+			"failures": failures,
+			"error":    failure,
+		})
+	}
+}
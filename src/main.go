@@ -1,16 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"log"
 	"mime"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 	"hubproxy/config"
@@ -57,7 +68,51 @@ func serveEmbedFile(c *gin.Context, filename string) {
 }
 
 func serveSPA(c *gin.Context) {
-	serveEmbedFile(c, "dist/index.html")
+	data, err := staticFiles.ReadFile("dist/index.html")
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", rewriteIndexBase(data, config.GetConfig().Server.BasePath))
+}
+
+// rewriteIndexBase 将前端 index.html 中的 <base href="/"> 改写为 basePath，静态资源与 API 请求均相对它解析
+func rewriteIndexBase(html []byte, basePath string) []byte {
+	if basePath == "" {
+		return html
+	}
+	return bytes.Replace(html, []byte(`<base href="/"`), []byte(`<base href="`+basePath+`/"`), 1)
+}
+
+// mountBasePath 去掉请求路径中的 basePath 前缀后交给路由；Docker 客户端固定访问根路径 /v2/，因此 /v2 仍在根路径可用
+func mountBasePath(router http.Handler, basePath string) http.Handler {
+	if basePath == "" {
+		return router
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, basePath)
+		if ok && (rest == "" || rest[0] == '/') {
+			r2 := new(http.Request)
+			*r2 = *r
+			u := *r.URL
+			u.Path = rest
+			if u.Path == "" {
+				u.Path = "/"
+			}
+			u.RawPath = ""
+			if rawRest, ok := strings.CutPrefix(r.URL.RawPath, basePath); ok {
+				u.RawPath = rawRest
+			}
+			r2.URL = &u
+			router.ServeHTTP(w, r2)
+			return
+		}
+		if r.URL.Path == "/v2" || strings.HasPrefix(r.URL.Path, "/v2/") {
+			router.ServeHTTP(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	})
 }
 
 func registerFrontendRoutes(router *gin.Engine, enabled bool) {
@@ -87,7 +142,7 @@ func registerFrontendRoutes(router *gin.Engine, enabled bool) {
 	})
 }
 
-func buildRouter(cfg *config.AppConfig) *gin.Engine {
+func buildRouter(cfg *config.AppConfig) http.Handler {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
 	utils.ConfigureTrustedProxies(router)
@@ -106,13 +161,15 @@ func buildRouter(cfg *config.AppConfig) *gin.Engine {
 	handlers.InitImageTarRoutes(router)
 	registerFrontendRoutes(router, cfg.Server.EnableFrontend)
 	handlers.RegisterSearchRoute(router)
+	handlers.RegisterPrefetchRoutes(router)
+	handlers.RegisterTagWatchRoutes(router)
 
 	router.Any("/token", handlers.ProxyDockerAuthGin)
 	router.Any("/token/*path", handlers.ProxyDockerAuthGin)
 	router.Any("/v2/*path", handlers.ProxyDockerRegistryGin)
 	router.NoRoute(handlers.GitHubProxyHandler)
 
-	return router
+	return utils.ConnectionAbortHandler(mountBasePath(router, cfg.Server.BasePath))
 }
 
 func main() {
@@ -122,47 +179,223 @@ func main() {
 	}
 
 	utils.InitHTTPClients()
+	if config.GetConfig().Server.CheckAliasCollisions {
+		go handlers.ReportAliasCollisions()
+	}
+	utils.InitCache()
 	globalLimiter = utils.InitGlobalLimiter()
 	handlers.InitDockerProxy()
 	handlers.InitImageStreamer()
 	handlers.InitDebouncer()
+	handlers.StartConfiguredPrefetch()
+	handlers.StartTagWatcher()
 
 	cfg := config.GetConfig()
 	router := buildRouter(cfg)
 
 	fmt.Printf("HubProxy 启动成功\n")
 	fmt.Printf("监听地址: %s:%d\n", cfg.Server.Host, cfg.Server.Port)
+	if cfg.Server.BasePath != "" {
+		fmt.Printf("路由前缀: %s\n", cfg.Server.BasePath)
+	}
 	fmt.Printf("限流配置: %d请求/%g小时\n", cfg.RateLimit.RequestLimit, cfg.RateLimit.PeriodHours)
 	if cfg.Server.EnableH2C {
 		fmt.Printf("H2c: 已启用\n")
 	}
+	if cfg.Server.TLS.Enabled {
+		fmt.Printf("TLS: 已启用\n")
+	}
 	fmt.Printf("版本号: %s\n", Version)
 	fmt.Printf("项目地址: https://github.com/sky22333/hubproxy\n")
 
+	// 所有请求的 context 派生自 requestCtx，优雅关闭超时后统一取消
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
 	server := &http.Server{
 		Addr:         fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
 		ReadTimeout:  60 * time.Second,
 		WriteTimeout: 30 * time.Minute,
 		IdleTimeout:  120 * time.Second,
+		BaseContext:  func(net.Listener) context.Context { return requestCtx },
 	}
+	servers := []gracefulServer{server}
+	serveErr := make(chan error, 1)
 
-	if cfg.Server.EnableH2C {
-		server.Handler = h2c.NewHandler(router, &http2.Server{
-			MaxConcurrentStreams:         250,
-			IdleTimeout:                  300 * time.Second,
-			MaxReadFrameSize:             4 << 20,
-			MaxUploadBufferPerConnection: 8 << 20,
-			MaxUploadBufferPerStream:     2 << 20,
-		})
+	if cfg.Server.TLS.Enabled {
+		plain, err := configureTLS(server, router, cfg)
+		if err != nil {
+			fmt.Printf("启动服务失败: %v\n", err)
+			return
+		}
+		if plain != nil {
+			servers = append(servers, plain)
+			go func() {
+				if err := plain.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					fmt.Printf("明文端口监听失败: %v\n", err)
+				}
+			}()
+		}
+		if cfg.Server.TLS.HTTP3 {
+			h3 := newHTTP3Server(server, router, requestCtx)
+			server.Handler = altSvcHandler(h3, server.Handler)
+			servers = append(servers, h3)
+			fmt.Printf("HTTP/3: 已启用 (UDP %d)\n", cfg.Server.Port)
+			go func() {
+				if err := h3.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					fmt.Printf("HTTP/3 监听失败: %v\n", err)
+				}
+			}()
+		}
+		go func() { serveErr <- server.ListenAndServeTLS("", "") }()
 	} else {
-		server.Handler = router
+		if cfg.Server.EnableH2C {
+			server.Handler = h2c.NewHandler(router, newHTTP2Server())
+		} else {
+			server.Handler = router
+		}
+		go func() { serveErr <- server.ListenAndServe() }()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-serveErr:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("启动服务失败: %v\n", err)
+		}
+	case sig := <-signals:
+		fmt.Printf("收到信号 %s，开始优雅关闭\n", sig)
+		gracefulShutdown(servers, cancelRequests, shutdownDelay(cfg), drainTimeout(cfg))
+	}
+	utils.StopBackground()
+	fmt.Printf("HubProxy 已停止\n")
+}
+
+// shutdownDelay 标记未就绪后继续服务的时间，供负载均衡与探针发现 /ready 的 503
+func shutdownDelay(cfg *config.AppConfig) time.Duration {
+	delay, err := time.ParseDuration(cfg.Server.ShutdownDelay)
+	if err != nil || delay < 0 {
+		return 5 * time.Second
+	}
+	return delay
+}
+
+// drainTimeout 优雅关闭时等待进行中传输的最长时间
+func drainTimeout(cfg *config.AppConfig) time.Duration {
+	timeout, err := time.ParseDuration(cfg.Server.DrainTimeout)
+	if err != nil || timeout < 0 {
+		return 60 * time.Second
+	}
+	return timeout
+}
+
+// gracefulServer TCP 与 HTTP/3 服务共有的关闭接口
+type gracefulServer interface {
+	Shutdown(ctx context.Context) error
+	Close() error
+}
+
+// gracefulShutdown 标记未就绪，在 delay 内照常服务，让探针通过 /ready 的 503 摘除流量；
+// 随后停止接收新连接，等待进行中的 blob、GitHub 与镜像 tar 传输结束，超过 timeout 后取消剩余请求的 context 并强制关闭连接
+func gracefulShutdown(servers []gracefulServer, cancelRequests context.CancelFunc, delay, timeout time.Duration) {
+	utils.SetDraining(true)
+	if delay > 0 {
+		fmt.Printf("已标记为未就绪，%s 后停止接收新连接\n", delay)
+		time.Sleep(delay)
+	}
+	fmt.Printf("进行中的传输: %d，最长等待 %s\n", utils.ActiveTransfers(), timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var drained atomic.Bool
+	drained.Store(true)
+	for _, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				drained.Store(false)
+			}
+		}()
 	}
+	wg.Wait()
+
+	// h2c 等被接管的连接不受 Shutdown 跟踪，按传输计数继续等待
+	if !drained.Load() || !utils.WaitTransfers(ctx) {
+		fmt.Printf("等待超时，取消剩余 %d 个传输\n", utils.ActiveTransfers())
+		cancelRequests()
+		for _, srv := range servers {
+			_ = srv.Close()
+		}
+		return
+	}
+	cancelRequests()
+}
+
+// newHTTP2Server HTTP/2 参数，h2c 与 TLS 上的 h2 共用
+func newHTTP2Server() *http2.Server {
+	return &http2.Server{
+		MaxConcurrentStreams:         250,
+		IdleTimeout:                  300 * time.Second,
+		MaxReadFrameSize:             4 << 20,
+		MaxUploadBufferPerConnection: 8 << 20,
+		MaxUploadBufferPerStream:     2 << 20,
+	}
+}
 
-	if err := server.ListenAndServe(); err != nil {
-		fmt.Printf("启动服务失败: %v\n", err)
+// newHTTP3Server 在与 TCP 相同的端口上通过 UDP 提供 HTTP/3，共用 TLS 配置与路由，
+// 限流与客户端 IP 识别沿用同一套中间件（http3 同样填充 RemoteAddr 与 TLS 状态）。
+// 每个 QUIC 连接的 context 在 requestCtx 取消时一并取消，与 TCP 的 BaseContext 行为一致
+func newHTTP3Server(server *http.Server, router http.Handler, requestCtx context.Context) *http3.Server {
+	return &http3.Server{
+		Addr:        server.Addr,
+		Handler:     router,
+		TLSConfig:   server.TLSConfig,
+		IdleTimeout: server.IdleTimeout,
+		ConnContext: func(ctx context.Context, _ *quic.Conn) context.Context {
+			ctx, cancel := context.WithCancelCause(ctx)
+			stop := context.AfterFunc(requestCtx, func() { cancel(context.Cause(requestCtx)) })
+			context.AfterFunc(ctx, func() { stop() })
+			return ctx
+		},
 	}
 }
 
+// altSvcHandler 在 TCP 响应中通过 Alt-Svc 声明 HTTP/3 端口，客户端后续请求可切换到 QUIC
+func altSvcHandler(h3 *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = h3.SetQUICHeaders(w.Header())
+		next.ServeHTTP(w, r)
+	})
+}
+
+// configureTLS 在主端口终止 TLS 并通过 ALPN 提供 HTTP/2，返回可选的明文端口服务（ACME HTTP-01 质询与跳转）
+func configureTLS(server *http.Server, router http.Handler, cfg *config.AppConfig) (*http.Server, error) {
+	tlsSetup, err := utils.NewTLSSetup()
+	if err != nil {
+		return nil, err
+	}
+	server.Handler = router
+	server.TLSConfig = tlsSetup.Config
+	if err := http2.ConfigureServer(server, newHTTP2Server()); err != nil {
+		return nil, err
+	}
+
+	if cfg.Server.TLS.HTTPPort <= 0 {
+		return nil, nil
+	}
+	fmt.Printf("明文端口: %d\n", cfg.Server.TLS.HTTPPort)
+	return &http.Server{
+		Addr:         fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.TLS.HTTPPort),
+		Handler:      tlsSetup.HTTPHandler(router, cfg.Server.TLS.RedirectHTTP, cfg.Server.Port),
+		ReadTimeout:  server.ReadTimeout,
+		WriteTimeout: server.WriteTimeout,
+		IdleTimeout:  server.IdleTimeout,
+		BaseContext:  server.BaseContext,
+	}, nil
+}
+
 func formatDuration(d time.Duration) string {
 	if d < time.Minute {
 		return fmt.Sprintf("%d秒", int(d.Seconds()))
@@ -187,13 +420,33 @@ func getUptimeInfo() (time.Duration, float64, string) {
 func initHealthRoutes(router *gin.Engine) {
 	router.GET("/ready", func(c *gin.Context) {
 		_, uptimeSec, uptimeHuman := getUptimeInfo()
+		status := http.StatusOK
+		if utils.IsDraining() {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, gin.H{
+			"ready":            status == http.StatusOK,
+			"active_transfers": utils.ActiveTransfers(),
+			"service":          "hubproxy",
+			"version":          Version,
+			"start_time_unix":  serviceStartTime.Unix(),
+			"uptime_sec":       uptimeSec,
+			"uptime_human":     uptimeHuman,
+		})
+	})
+
+	router.GET("/api/webhooks/deliveries", utils.AdminAuthMiddleware(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"deliveries": utils.WebhookDeliveries()})
+	})
+
+	router.GET("/api/cache/stats", func(c *gin.Context) {
+		usedBytes, maxBytes := utils.SharedCache.Usage()
 		c.JSON(http.StatusOK, gin.H{
-			"ready":           true,
-			"service":         "hubproxy",
-			"version":         Version,
-			"start_time_unix": serviceStartTime.Unix(),
-			"uptime_sec":      uptimeSec,
-			"uptime_human":    uptimeHuman,
+			"used_bytes": usedBytes,
+			"max_bytes":  maxBytes,
+			"namespaces": utils.SharedCache.Stats(),
+			// blob 传输时 digest 校验失败的累计次数
+			"blob_digest_mismatches": handlers.BlobDigestMismatches(),
 		})
 	})
 }
@@ -1,21 +1,37 @@
 package main
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	"github.com/gin-gonic/gin"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 	"hubproxy/config"
 	"hubproxy/handlers"
 	"hubproxy/utils"
 )
 
-func newTestRouter(t *testing.T, configBody string) *gin.Engine {
+func newTestRouter(t *testing.T, configBody string) http.Handler {
 	t.Helper()
 
 	path := filepath.Join(t.TempDir(), "config.toml")
@@ -29,6 +45,7 @@ func newTestRouter(t *testing.T, configBody string) *gin.Engine {
 	}
 
 	utils.InitHTTPClients()
+	utils.InitCache()
 	globalLimiter = utils.InitGlobalLimiter()
 	handlers.InitDockerProxy()
 	handlers.InitImageStreamer()
@@ -96,7 +113,7 @@ func TestSingleImageDownloadPrepareReturnsURL(t *testing.T) {
 	if !strings.Contains(got.DownloadURL, "image=nginx") || !strings.Contains(got.DownloadURL, "token=") {
 		t.Fatalf("download_url = %q", got.DownloadURL)
 	}
-	if !strings.HasPrefix(got.DownloadURL, "/api/image/download?") {
+	if !strings.HasPrefix(got.DownloadURL, "http://example.com/api/image/download?") {
 		t.Fatalf("download_url = %q", got.DownloadURL)
 	}
 }
@@ -116,7 +133,7 @@ func TestBatchImageDownloadPrepareReturnsURL(t *testing.T) {
 	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
 		t.Fatal(err)
 	}
-	if !strings.HasPrefix(got.DownloadURL, "/api/image/batch?token=") {
+	if !strings.HasPrefix(got.DownloadURL, "http://example.com/api/image/batch?token=") {
 		t.Fatalf("download_url = %q", got.DownloadURL)
 	}
 }
@@ -143,6 +160,47 @@ func TestGitHubNoRouteRejectsUnsupportedHost(t *testing.T) {
 	}
 }
 
+func TestBasePathRouting(t *testing.T) {
+	router := newTestRouter(t, `
+[server]
+basePath = "/hub/"
+`)
+
+	for path, want := range map[string]int{
+		"/hub/ready":   http.StatusOK,
+		"/hub/v2/":     http.StatusOK,
+		"/v2/":         http.StatusOK,
+		"/ready":       http.StatusNotFound,
+		"/hubx/ready":  http.StatusNotFound,
+		"/api/search":  http.StatusNotFound,
+		"/https://x.y": http.StatusNotFound,
+	} {
+		if w := performRequest(router, http.MethodGet, path, ""); w.Code != want {
+			t.Errorf("%s status = %d, want %d", path, w.Code, want)
+		}
+	}
+
+	w := performRequest(router, http.MethodGet, "/hub/api/image/download?image=nginx&mode=prepare", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200; body=%s", w.Code, w.Body.String())
+	}
+	var got struct {
+		DownloadURL string `json:"download_url"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got.DownloadURL, "http://example.com/hub/api/image/download?") {
+		t.Fatalf("download_url = %q", got.DownloadURL)
+	}
+
+	html := `<head><base href="/" /><script src="./assets/index.js"></script></head>`
+	want := `<head><base href="/hub/" /><script src="./assets/index.js"></script></head>`
+	if got := string(rewriteIndexBase([]byte(html), "/hub")); got != want {
+		t.Fatalf("rewriteIndexBase = %q", got)
+	}
+}
+
 func TestDockerV2PingAndInvalidPath(t *testing.T) {
 	router := newTestRouter(t, "")
 
@@ -194,3 +252,379 @@ enableFrontend = true
 		t.Fatalf("SPA shell missing: %s", w.Body.String())
 	}
 }
+
+func TestCacheStatsRoute(t *testing.T) {
+	router := newTestRouter(t, `
+[cache]
+maxMemoryMB = 64
+quotasMB = { manifests = 8 }
+`)
+
+	utils.GlobalCache.Set(utils.BuildManifestCacheKey("example/app", "sha256:abc"), []byte("{}"), "application/json", nil, time.Minute)
+	utils.GlobalCache.Get(utils.BuildManifestCacheKey("example/app", "sha256:abc"))
+
+	w := performRequest(router, http.MethodGet, "/api/cache/stats", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body=%s", w.Code, w.Body.String())
+	}
+
+	var got struct {
+		MaxBytes   int64                       `json:"max_bytes"`
+		Namespaces map[string]utils.CacheStats `json:"namespaces"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	manifests := got.Namespaces[utils.CacheNamespaceManifests]
+	if got.MaxBytes != 64<<20 || manifests.QuotaBytes != 8<<20 || manifests.Hits == 0 || manifests.Entries == 0 {
+		t.Fatalf("unexpected stats: %s", w.Body.String())
+	}
+}
+
+func TestPrefetchRouteRequiresAdminToken(t *testing.T) {
+	router := newTestRouter(t, "")
+	w := performRequest(router, http.MethodGet, "/api/cache/prefetch", "")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status without admin token = %d, want 403", w.Code)
+	}
+
+	router = newTestRouter(t, `
+[admin]
+token = "secret"
+`)
+	w = performRequest(router, http.MethodPost, "/api/cache/prefetch", `{"images":["nginx"]}`)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status without bearer = %d, want 401", w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/cache/prefetch", strings.NewReader(`{"images":[]}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status for empty images = %d, want 400; body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestGracefulShutdownDrainsThenCancels(t *testing.T) {
+	router := newTestRouter(t, "")
+	defer utils.SetDraining(false)
+	tlsConfig, pool := newTestTLSConfig(t)
+	errShutdown := errors.New("shutdown")
+
+	// run 发起一个进行中的传输后执行 shutdown，返回请求 context 的取消原因，传输正常结束时为 nil；
+	// 原因为 errShutdown 说明请求 context 派生自 requestCtx，而不是因连接关闭被取消
+	run := func(useHTTP3 bool, releaseAfter time.Duration, shutdown func(gracefulServer, context.CancelFunc)) error {
+		release := make(chan struct{})
+		started := make(chan struct{}, 1)
+		canceled := make(chan error, 1)
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer utils.BeginTransfer()()
+			started <- struct{}{}
+			select {
+			case <-release:
+				canceled <- nil
+			case <-r.Context().Done():
+				canceled <- context.Cause(r.Context())
+			}
+		})
+
+		requestCtx, cancelCause := context.WithCancelCause(context.Background())
+		cancelRequests := func() { cancelCause(errShutdown) }
+		var server gracefulServer
+		var url string
+		client := &http.Client{}
+		if useHTTP3 {
+			h3 := newHTTP3Server(&http.Server{TLSConfig: tlsConfig}, handler, requestCtx)
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go func() { _ = h3.Serve(conn) }()
+			defer h3.Close()
+			server, url = h3, "https://"+conn.LocalAddr().String()
+			client.Transport = &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+		} else {
+			srv := httptest.NewUnstartedServer(handler)
+			srv.Config.BaseContext = func(net.Listener) context.Context { return requestCtx }
+			srv.Start()
+			defer srv.Close()
+			server, url = srv.Config, srv.URL
+		}
+
+		go func() {
+			resp, err := client.Get(url + "/blob")
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		<-started
+
+		if releaseAfter > 0 {
+			time.AfterFunc(releaseAfter, func() { close(release) })
+		}
+		done := make(chan struct{})
+		go func() {
+			shutdown(server, cancelRequests)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("shutdown did not return")
+		}
+		select {
+		case err := <-canceled:
+			return err
+		case <-time.After(5 * time.Second):
+			close(release)
+			return <-canceled
+		}
+	}
+	graceful := func(timeout time.Duration) func(gracefulServer, context.CancelFunc) {
+		return func(server gracefulServer, cancelRequests context.CancelFunc) {
+			gracefulShutdown([]gracefulServer{server}, cancelRequests, 0, timeout)
+			if !utils.IsDraining() {
+				t.Error("service not marked as draining")
+			}
+		}
+	}
+
+	for _, useHTTP3 := range []bool{false, true} {
+		if err := run(useHTTP3, 100*time.Millisecond, graceful(5*time.Second)); err != nil {
+			t.Fatalf("http3=%v: in-flight transfer was canceled before the drain timeout: %v", useHTTP3, err)
+		}
+		if run(useHTTP3, 0, graceful(100*time.Millisecond)) == nil {
+			t.Fatalf("http3=%v: transfer context not canceled after the drain timeout", useHTTP3)
+		}
+	}
+	if err := run(false, 0, graceful(100*time.Millisecond)); !errors.Is(err, errShutdown) {
+		t.Fatalf("TCP request context not derived from requestCtx: %v", err)
+	}
+	// http3 的 Shutdown 超时后会自行关闭连接，因此单独验证 cancelRequests 能取消 QUIC 连接上的请求
+	cancelOnly := func(_ gracefulServer, cancelRequests context.CancelFunc) { cancelRequests() }
+	if err := run(true, 0, cancelOnly); !errors.Is(err, errShutdown) {
+		t.Fatalf("HTTP/3 request context not derived from requestCtx: %v", err)
+	}
+	if utils.ActiveTransfers() != 0 {
+		t.Fatalf("active transfers = %d", utils.ActiveTransfers())
+	}
+
+	w := performRequest(router, http.MethodGet, "/ready", "")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"ready":false`) {
+		t.Fatalf("/ready while draining: %d %s", w.Code, w.Body.String())
+	}
+}
+
+// newTestTLSConfig 生成 127.0.0.1 与 127.0.0.2 的自签名证书，返回服务端配置与信任该证书的根证书池
+func newTestTLSConfig(t *testing.T) (*tls.Config, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("127.0.0.2")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, pool
+}
+
+func TestGracefulShutdownDelayServesNotReady(t *testing.T) {
+	router := newTestRouter(t, "")
+	defer utils.SetDraining(false)
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 5 * time.Second}
+
+	done := make(chan struct{})
+	go func() {
+		gracefulShutdown([]gracefulServer{srv.Config}, func() {}, 500*time.Millisecond, time.Second)
+		close(done)
+	}()
+	for !utils.IsDraining() {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// 延迟期间新连接仍被接受，探针能看到 503
+	resp, err := client.Get(srv.URL + "/ready")
+	if err != nil {
+		t.Fatalf("new connection rejected during shutdown delay: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("/ready during shutdown delay: status %d, want 503", resp.StatusCode)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("gracefulShutdown did not return")
+	}
+	if _, err := client.Get(srv.URL + "/ready"); err == nil {
+		t.Fatal("listener still accepting connections after shutdown")
+	}
+}
+
+func TestHTTP3ListenerSharesRouter(t *testing.T) {
+	router := newTestRouter(t, `
+[security]
+blackList = ["127.0.0.2"]
+`)
+
+	tlsConfig, pool := newTestTLSConfig(t)
+	tcpServer := &http.Server{
+		Addr:      "127.0.0.1:0",
+		Handler:   router,
+		TLSConfig: tlsConfig,
+	}
+	h3 := newHTTP3Server(tcpServer, router, context.Background())
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = h3.Serve(conn) }()
+	defer h3.Close()
+
+	client := &http.Client{
+		Transport: &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		Timeout:   5 * time.Second,
+	}
+	resp, err := client.Get("https://" + conn.LocalAddr().String() + "/ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 3 {
+		t.Fatalf("HTTP/3 /ready: status %d proto %s", resp.StatusCode, resp.Proto)
+	}
+
+	w := httptest.NewRecorder()
+	altSvcHandler(h3, router).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if got := w.Header().Get("Alt-Svc"); !strings.HasPrefix(got, `h3=":`) {
+		t.Fatalf("Alt-Svc = %q", got)
+	}
+
+	// 黑名单按 QUIC 连接的对端地址生效，与 TCP 路径一致
+	blockedConn, err := net.ListenPacket("udp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("127.0.0.2 不可用: %v", err)
+	}
+	go func() { _ = h3.Serve(blockedConn) }()
+	blockedClient := &http.Client{
+		Transport: &http3.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+			Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+				udp, err := net.ListenPacket("udp", "127.0.0.2:0")
+				if err != nil {
+					return nil, err
+				}
+				udpAddr, err := net.ResolveUDPAddr("udp", addr)
+				if err != nil {
+					return nil, err
+				}
+				return quic.DialEarly(ctx, udp, udpAddr, tlsCfg, cfg)
+			},
+		},
+		Timeout: 5 * time.Second,
+	}
+	resp, err = blockedClient.Get("https://" + blockedConn.LocalAddr().String() + "/ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("blacklisted HTTP/3 client: status %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestBlobDigestMismatchAbortsConnection(t *testing.T) {
+	content := []byte(strings.Repeat("hubproxy-abort-layer-", 8192))
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+	corrupted := append([]byte(nil), content...)
+	corrupted[len(corrupted)-1] ^= 0xff
+
+	var body atomic.Value
+	body.Store(corrupted)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/blobs/") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		data := body.Load().([]byte)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method != http.MethodHead {
+			w.Write(data)
+		}
+	}))
+	defer upstream.Close()
+
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	router := newTestRouter(t, `
+[registries."`+host+`"]
+upstream = "`+host+`"
+enabled = true
+scheme = "http"
+`)
+
+	h1 := httptest.NewServer(router)
+	defer h1.Close()
+	h2 := httptest.NewUnstartedServer(router)
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+
+	fetch := func(srv *httptest.Server) ([]byte, error) {
+		resp, err := srv.Client().Get(srv.URL + "/v2/" + host + "/team/app/blobs/" + digest)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("status %d", resp.StatusCode)
+		}
+		return io.ReadAll(resp.Body)
+	}
+
+	for _, srv := range []*httptest.Server{h1, h2} {
+		before := handlers.BlobDigestMismatches()
+		data, err := fetch(srv)
+		// HTTP/1 断开连接；HTTP/2 必须以 RST_STREAM 结束，而不是带 END_STREAM 的短响应
+		if err == nil {
+			t.Fatalf("%s: corrupted blob ended cleanly with %d bytes", srv.URL, len(data))
+		}
+		if srv == h2 && !strings.Contains(err.Error(), "INTERNAL_ERROR") {
+			t.Fatalf("HTTP/2 stream was not reset: %v", err)
+		}
+		if len(data) >= len(content) {
+			t.Fatalf("%s: client received %d of %d bytes", srv.URL, len(data), len(content))
+		}
+		if handlers.BlobDigestMismatches() != before+1 {
+			t.Fatalf("%s: mismatch counter = %d", srv.URL, handlers.BlobDigestMismatches())
+		}
+	}
+
+	body.Store(content)
+	for _, srv := range []*httptest.Server{h1, h2} {
+		data, err := fetch(srv)
+		if err != nil || string(data) != string(content) {
+			t.Fatalf("%s: valid blob err=%v bytes=%d", srv.URL, err, len(data))
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// abortFlagKey 请求 context 中中断标记的键
+type abortFlagKey struct{}
+
+// ConnectionAbortHandler 包裹整个路由，处理器通过 AbortConnection 标记后，在 gin 之外以
+// http.ErrAbortHandler 中断：HTTP/1 关闭连接，HTTP/2 与 HTTP/3 重置流，客户端不会收到正常结束的响应。
+// gin 的 Recovery 会吞掉处理器内的 ErrAbortHandler，因此不能在路由内直接 panic
+func ConnectionAbortHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		aborted := new(bool)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), abortFlagKey{}, aborted)))
+		if *aborted {
+			panic(http.ErrAbortHandler)
+		}
+	})
+}
+
+// AbortConnection 在传输层中断当前响应；未经 ConnectionAbortHandler 包裹时直接 panic
+func AbortConnection(c *gin.Context) {
+	aborted, ok := c.Request.Context().Value(abortFlagKey{}).(*bool)
+	if !ok {
+		panic(http.ErrAbortHandler)
+	}
+	*aborted = true
+	c.Abort()
+}
@@ -20,12 +20,21 @@ type AccessController struct {
 
 // DockerImageInfo Docker镜像信息
 type DockerImageInfo struct {
+	Registry   string
 	Namespace  string
 	Repository string
 	Tag        string
 	FullName   string
 }
 
+// defaultDockerRegistry 未写Registry域名的镜像默认来自Docker Hub
+const defaultDockerRegistry = "docker.io"
+
+// isRegistryHost 判断路径首段是否为Registry域名
+func isRegistryHost(part string) bool {
+	return strings.Contains(part, ".") || strings.Contains(part, ":") || part == "localhost"
+}
+
 // GlobalAccessController 全局访问控制器实例
 var GlobalAccessController = &AccessController{}
 
@@ -45,21 +54,23 @@ func (ac *AccessController) ParseDockerImage(image string) DockerImageInfo {
 		tag = "latest"
 	}
 
+	registry := defaultDockerRegistry
 	var namespace, repository string
 	if strings.Contains(image, "/") {
 		parts := strings.Split(image, "/")
 		if len(parts) >= 2 {
-			if strings.Contains(parts[0], ".") {
+			if isRegistryHost(parts[0]) {
+				registry = parts[0]
 				if len(parts) >= 3 {
 					namespace = parts[1]
-					repository = parts[2]
+					repository = strings.Join(parts[2:], "/")
 				} else {
 					namespace = "library"
 					repository = parts[1]
 				}
 			} else {
 				namespace = parts[0]
-				repository = parts[1]
+				repository = strings.Join(parts[1:], "/")
 			}
 		}
 	} else {
@@ -69,7 +80,12 @@ func (ac *AccessController) ParseDockerImage(image string) DockerImageInfo {
 
 	fullName := namespace + "/" + repository
 
+	if registry == "index.docker.io" || registry == "registry-1.docker.io" {
+		registry = defaultDockerRegistry
+	}
+
 	return DockerImageInfo{
+		Registry:   registry,
 		Namespace:  namespace,
 		Repository: repository,
 		Tag:        tag,
@@ -118,9 +134,11 @@ func (ac *AccessController) CheckGitHubAccess(matches []string) (allowed bool, r
 }
 
 // matchImageInList 检查Docker镜像是否在指定列表中
+// 以Registry域名开头的规则（如 ghcr.io/org/*）匹配带Registry的完整名称，其余规则仅匹配仓库名
 func (ac *AccessController) matchImageInList(imageInfo DockerImageInfo, list []string) bool {
 	fullName := strings.ToLower(imageInfo.FullName)
 	namespace := strings.ToLower(imageInfo.Namespace)
+	qualifiedName := strings.ToLower(imageInfo.Registry) + "/" + fullName
 
 	for _, item := range list {
 		item = strings.ToLower(strings.TrimSpace(item))
@@ -128,6 +146,16 @@ func (ac *AccessController) matchImageInList(imageInfo DockerImageInfo, list []s
 			continue
 		}
 
+		if first, _, _ := strings.Cut(item, "/"); isRegistryHost(first) {
+			if qualifiedName == item || strings.HasPrefix(qualifiedName, item+"/") {
+				return true
+			}
+			if strings.HasSuffix(item, "*") && strings.HasPrefix(qualifiedName, strings.TrimSuffix(item, "*")) {
+				return true
+			}
+			continue
+		}
+
 		if fullName == item {
 			return true
 		}
@@ -84,3 +84,31 @@ blackList = ["allowed/blocked"]
 		t.Fatal("repo outside whitelist allowed")
 	}
 }
+
+func TestDockerAccessRegistryQualifiedRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	data := []byte(`
+[access]
+blackList = ["ghcr.io/bad/*", "docker.io/library/redis"]
+`)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	if allowed, _ := GlobalAccessController.CheckDockerAccess("ghcr.io/bad/app"); allowed {
+		t.Fatal("ghcr.io/bad/app allowed")
+	}
+	if allowed, reason := GlobalAccessController.CheckDockerAccess("bad/app"); !allowed {
+		t.Fatalf("docker hub bad/app denied by ghcr rule: %s", reason)
+	}
+	if allowed, _ := GlobalAccessController.CheckDockerAccess("redis:7"); allowed {
+		t.Fatal("docker.io/library/redis allowed")
+	}
+	if allowed, reason := GlobalAccessController.CheckDockerAccess("quay.io/library/redis"); !allowed {
+		t.Fatalf("quay.io redis denied by docker.io rule: %s", reason)
+	}
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"hubproxy/config"
+)
+
+// AdminAuthMiddleware 管理接口鉴权，要求 Authorization: Bearer <[admin].token>；未配置令牌时接口关闭
+func AdminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := config.GetConfig().Admin.Token
+		if token == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "管理接口未启用，请配置 [admin].token"})
+			c.Abort()
+			return
+		}
+
+		provided, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(provided)), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="hubproxy"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "管理令牌无效"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -20,31 +22,116 @@ type CachedItem struct {
 	ExpiresAt   time.Time
 }
 
-// UniversalCache 通用缓存
+// defaultCacheBytes 未调用 InitCache 时的内存预算
+const defaultCacheBytes = 256 << 20
+
+// SharedCache token、manifest 与搜索结果共用的内存缓存
+var SharedCache = NewLRUCache(defaultCacheBytes, nil)
+
+// InitCache 按配置设置共享缓存的总预算与命名空间配额
+func InitCache() {
+	cfg := config.GetConfig()
+	quotas := make(map[string]int64, len(cfg.Cache.QuotasMB))
+	for ns, mb := range cfg.Cache.QuotasMB {
+		quotas[ns] = int64(mb) << 20
+	}
+	SharedCache.Configure(int64(cfg.Cache.MaxMemoryMB)<<20, quotas)
+}
+
+// UniversalCache 通用缓存，条目按 key 前缀归入 SharedCache 的命名空间
 type UniversalCache struct {
-	cache sync.Map
+	store *LRUCache
+	once  sync.Once
 }
 
-var GlobalCache = &UniversalCache{}
+var GlobalCache = &UniversalCache{store: SharedCache}
 
-// Get 获取缓存项
-func (c *UniversalCache) Get(key string) *CachedItem {
-	if v, ok := c.cache.Load(key); ok {
-		if cached := v.(*CachedItem); time.Now().Before(cached.ExpiresAt) {
-			return cached
+// backend 返回底层缓存，零值 UniversalCache 使用独立的缓存实例
+func (c *UniversalCache) backend() *LRUCache {
+	c.once.Do(func() {
+		if c.store == nil {
+			c.store = NewLRUCache(defaultCacheBytes, nil)
 		}
-		c.cache.Delete(key)
+	})
+	return c.store
+}
+
+// cacheNamespace 根据 BuildCacheKey 生成的前缀确定命名空间
+func cacheNamespace(key string) string {
+	prefix, _, _ := strings.Cut(key, ":")
+	switch prefix {
+	case "token":
+		return CacheNamespaceTokens
+	case "manifest", "manifest-tag", "blob":
+		return CacheNamespaceManifests
+	}
+	return CacheNamespaceDefault
+}
+
+// itemSize 估算缓存项占用的字节数
+func (item *CachedItem) itemSize() int64 {
+	size := int64(len(item.Data) + len(item.ContentType))
+	for key, value := range item.Headers {
+		size += int64(len(key) + len(value))
+	}
+	return size
+}
+
+// Get 获取缓存项，离线模式下过期条目同样返回；token 不得超出上游 expires_in，过期后始终视为未命中
+func (c *UniversalCache) Get(key string) *CachedItem {
+	cached, fresh := c.GetWithStale(key)
+	if cached == nil {
+		return nil
+	}
+	isToken := cacheNamespace(key) == CacheNamespaceTokens
+	if fresh || (IsOfflineMode() && !isToken) {
+		return cached
+	}
+	if isToken || !cached.WithinStaleGrace() {
+		c.backend().Delete(cacheNamespace(key), key)
 	}
 	return nil
 }
 
+// WithinStaleGrace 判断过期条目是否仍处于宽限期内，可在上游故障时使用
+func (item *CachedItem) WithinStaleGrace() bool {
+	return time.Now().Before(item.ExpiresAt.Add(GetStaleGracePeriod()))
+}
+
+// GetWithStale 获取缓存项，已过期但尚未清理的条目同样返回，fresh 表示是否仍在有效期内
+func (c *UniversalCache) GetWithStale(key string) (*CachedItem, bool) {
+	v, _, ok := c.backend().Get(cacheNamespace(key), key)
+	if !ok {
+		return nil, false
+	}
+	cached := v.(*CachedItem)
+	return cached, time.Now().Before(cached.ExpiresAt)
+}
+
 func (c *UniversalCache) Set(key string, data []byte, contentType string, headers map[string]string, ttl time.Duration) {
-	c.cache.Store(key, &CachedItem{
+	item := &CachedItem{
 		Data:        data,
 		ContentType: contentType,
 		Headers:     headers,
 		ExpiresAt:   time.Now().Add(ttl),
-	})
+	}
+	c.backend().Set(cacheNamespace(key), key, item, item.itemSize(), ttl)
+}
+
+// Expire 将条目标记为已过期但保留内容，下次读取时按过期条目处理（条件请求、stale-if-error）
+func (c *UniversalCache) Expire(key string) {
+	cached, _ := c.GetWithStale(key)
+	if cached == nil {
+		return
+	}
+	expired := *cached
+	expired.ExpiresAt = time.Now()
+	c.backend().Set(cacheNamespace(key), key, &expired, expired.itemSize(), 0)
+}
+
+// Delete 删除缓存条目
+func (c *UniversalCache) Delete(key string) {
+	c.backend().Delete(cacheNamespace(key), key)
 }
 
 func (c *UniversalCache) GetToken(key string) string {
@@ -67,11 +154,36 @@ func BuildTokenCacheKey(query string) string {
 	return BuildCacheKey("token", query)
 }
 
-func BuildManifestCacheKey(imageRef, reference string) string {
-	key := fmt.Sprintf("%s:%s", imageRef, reference)
+// CredentialFingerprint 返回客户端凭据的 sha256 摘要，匿名请求返回空串；用于隔离不同凭据换得的 token
+func CredentialFingerprint(authorization string) string {
+	if authorization == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(authorization))
+	return hex.EncodeToString(sum[:])
+}
+
+// BuildManifestCacheKey manifest 按 digest 缓存，同一 tag 的不同媒体类型互不覆盖
+func BuildManifestCacheKey(imageRef, digest string) string {
+	key := fmt.Sprintf("%s@%s", imageRef, digest)
 	return BuildCacheKey("manifest", key)
 }
 
+// BuildManifestTagCacheKey tag→digest 索引的key
+func BuildManifestTagCacheKey(imageRef, tag string) string {
+	key := fmt.Sprintf("%s:%s", imageRef, tag)
+	return BuildCacheKey("manifest-tag", key)
+}
+
+// MaxCachedBlobSize 仅缓存镜像config等小 blob，layer 仍直接流式转发
+const MaxCachedBlobSize = 1 << 20
+
+// BuildBlobCacheKey blob 按 digest 缓存，并按来源 Registry 与凭据（scope）隔离，
+// 经凭据拉取的私有 blob 不会被其他 Registry 或匿名映射的请求命中
+func BuildBlobCacheKey(scope, digest string) string {
+	return BuildCacheKey("blob", scope+"@"+digest)
+}
+
 func GetManifestTTL(reference string) time.Duration {
 	cfg := config.GetConfig()
 	defaultTTL := 30 * time.Minute
@@ -93,22 +205,22 @@ func GetManifestTTL(reference string) time.Duration {
 	return defaultTTL
 }
 
-// ExtractTTLFromResponse 从响应中智能提取TTL
+// defaultTokenExpiresIn Docker token 规范中未返回 expires_in 时的有效期
+const defaultTokenExpiresIn = 60 * time.Second
+
+// ExtractTTLFromResponse 从响应中智能提取TTL，始终短于上游 expires_in（缺省按 60 秒）：
+// 预留 5 分钟（有效期较短时预留五分之一）的余量，避免客户端拿到即将失效的 token
 func ExtractTTLFromResponse(responseBody []byte) time.Duration {
 	var tokenResp struct {
 		ExpiresIn int `json:"expires_in"`
 	}
 
-	defaultTTL := 30 * time.Minute
-
+	expiresIn := defaultTokenExpiresIn
 	if json.Unmarshal(responseBody, &tokenResp) == nil && tokenResp.ExpiresIn > 0 {
-		safeTTL := time.Duration(tokenResp.ExpiresIn-300) * time.Second
-		if safeTTL > 5*time.Minute {
-			return safeTTL
-		}
+		expiresIn = time.Duration(tokenResp.ExpiresIn) * time.Second
 	}
 
-	return defaultTTL
+	return expiresIn - min(5*time.Minute, expiresIn/5)
 }
 
 func WriteTokenResponse(c *gin.Context, cachedBody string) {
@@ -128,12 +240,37 @@ func WriteCachedResponse(c *gin.Context, item *CachedItem) {
 	c.Data(200, item.ContentType, item.Data)
 }
 
+// GetStaleGracePeriod 过期缓存的宽限期，未配置或格式错误时为 0
+func GetStaleGracePeriod() time.Duration {
+	cfg := config.GetConfig()
+	if cfg.Cache.StaleGracePeriod == "" {
+		return 0
+	}
+	grace, err := time.ParseDuration(cfg.Cache.StaleGracePeriod)
+	if err != nil || grace < 0 {
+		return 0
+	}
+	return grace
+}
+
+// IsOfflineMode 检查是否处于离线模式
+func IsOfflineMode() bool {
+	cfg := config.GetConfig()
+	return cfg.Cache.Offline
+}
+
 // IsCacheEnabled 检查缓存是否启用
 func IsCacheEnabled() bool {
 	cfg := config.GetConfig()
 	return cfg.TokenCache.Enabled
 }
 
+// IsAuthenticatedTokenCacheEnabled 检查携带凭据的 token 请求是否允许缓存
+func IsAuthenticatedTokenCacheEnabled() bool {
+	cfg := config.GetConfig()
+	return cfg.TokenCache.CacheAuthenticated
+}
+
 // IsTokenCacheEnabled 检查token缓存是否启用
 func IsTokenCacheEnabled() bool {
 	return IsCacheEnabled()
@@ -145,20 +282,18 @@ func init() {
 		ticker := time.NewTicker(20 * time.Minute)
 		defer ticker.Stop()
 
-		for range ticker.C {
-			now := time.Now()
-			expiredKeys := make([]string, 0)
-
-			GlobalCache.cache.Range(func(key, value interface{}) bool {
-				if cached := value.(*CachedItem); now.After(cached.ExpiresAt) {
-					expiredKeys = append(expiredKeys, key.(string))
-				}
-				return true
-			})
-
-			for _, key := range expiredKeys {
-				GlobalCache.cache.Delete(key)
+		for {
+			select {
+			case <-ticker.C:
+			case <-backgroundCtx.Done():
+				return
+			}
+			// 离线模式下缓存是唯一数据来源，不清理
+			if IsOfflineMode() {
+				continue
 			}
+
+			SharedCache.RemoveExpired(GetStaleGracePeriod())
 		}
 	}()
 }
@@ -34,8 +34,12 @@ func TestExtractTTLFromResponse(t *testing.T) {
 		t.Fatalf("TTL = %s, want 55m", ttl)
 	}
 
-	if ttl := ExtractTTLFromResponse([]byte(`{}`)); ttl != 30*time.Minute {
-		t.Fatalf("default TTL = %s", ttl)
+	if ttl := ExtractTTLFromResponse([]byte(`{}`)); ttl != 48*time.Second {
+		t.Fatalf("default TTL = %s, want 48s", ttl)
+	}
+
+	if ttl := ExtractTTLFromResponse([]byte(`{"expires_in":300}`)); ttl != 4*time.Minute {
+		t.Fatalf("short-lived TTL = %s, want 4m", ttl)
 	}
 }
 
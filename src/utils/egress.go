@@ -0,0 +1,227 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"hubproxy/config"
+)
+
+// dockerHubEgressHosts registries 中 docker.io 对应的上游主机：Registry、认证端点与 blob CDN
+var dockerHubEgressHosts = []string{
+	"registry-1.docker.io",
+	"auth.docker.io",
+	"production.cloudflare.docker.com",
+}
+
+// defaultProxyPorts 代理地址省略端口时健康检查使用的端口
+var defaultProxyPorts = map[string]string{
+	"http":    "80",
+	"https":   "443",
+	"socks5":  "1080",
+	"socks5h": "1080",
+}
+
+// proxyPool 一条出站规则的代理池，proxies 为空表示直连
+type proxyPool struct {
+	proxies  []*url.URL
+	healthy  []atomic.Bool
+	failover bool
+	next     atomic.Uint64
+}
+
+// egressRule 编译后的出站规则
+type egressRule struct {
+	patterns []string
+	hosts    map[string]bool
+	pool     *proxyPool
+}
+
+// egressRouter 按上游主机选择出站代理，未命中规则时使用 access.proxy 或环境变量代理
+type egressRouter struct {
+	rules    []egressRule
+	fallback *url.URL
+	cancel   context.CancelFunc
+}
+
+var (
+	currentEgress   *egressRouter
+	currentEgressMu sync.Mutex
+)
+
+// newEgressRouter 根据配置编译出站规则，非法代理地址在加载配置时已被拒绝，这里直接跳过
+func newEgressRouter(cfg *config.AppConfig) *egressRouter {
+	router := &egressRouter{}
+	if cfg.Access.Proxy != "" {
+		if u, err := config.ParseProxyURL(cfg.Access.Proxy); err == nil {
+			router.fallback = u
+		}
+	}
+
+	for _, rule := range cfg.Egress.Rules {
+		compiled := egressRule{hosts: make(map[string]bool), pool: &proxyPool{failover: rule.Strategy == "failover"}}
+		for _, pattern := range rule.Hosts {
+			compiled.patterns = append(compiled.patterns, strings.ToLower(strings.TrimSpace(pattern)))
+		}
+		for _, registry := range rule.Registries {
+			for _, host := range registryEgressHosts(registry, cfg.Registries[registry]) {
+				compiled.hosts[host] = true
+			}
+		}
+		for _, raw := range rule.Proxies {
+			if u, err := config.ParseProxyURL(raw); err == nil {
+				compiled.pool.proxies = append(compiled.pool.proxies, u)
+			}
+		}
+		compiled.pool.healthy = make([]atomic.Bool, len(compiled.pool.proxies))
+		for i := range compiled.pool.healthy {
+			compiled.pool.healthy[i].Store(true)
+		}
+		router.rules = append(router.rules, compiled)
+	}
+	return router
+}
+
+// registryEgressHosts 返回 Registry 映射访问的上游主机（不含端口）
+func registryEgressHosts(registry string, mapping config.RegistryMapping) []string {
+	if registry == "docker.io" && mapping.Upstream == "" {
+		return dockerHubEgressHosts
+	}
+	var hosts []string
+	for _, endpoint := range []string{mapping.Upstream, mapping.AuthHost} {
+		host, _, _ := strings.Cut(endpoint, "/")
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host != "" {
+			hosts = append(hosts, strings.ToLower(host))
+		}
+	}
+	return hosts
+}
+
+// proxyFor 作为 http.Transport.Proxy 使用，返回 nil 表示直连
+func (r *egressRouter) proxyFor(req *http.Request) (*url.URL, error) {
+	host := strings.ToLower(req.URL.Hostname())
+	for i := range r.rules {
+		if r.rules[i].matches(host) {
+			return r.rules[i].pool.pick(), nil
+		}
+	}
+	if r.fallback != nil {
+		return r.fallback, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// matches 判断主机是否命中规则
+func (r *egressRule) matches(host string) bool {
+	if r.hosts[host] {
+		return true
+	}
+	for _, pattern := range r.patterns {
+		if matched, err := path.Match(pattern, host); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// pick 按策略选择健康的代理；全部不可用时仍按策略选择，避免规则静默退化为直连
+func (p *proxyPool) pick() *url.URL {
+	n := len(p.proxies)
+	if n == 0 {
+		return nil
+	}
+	start := 0
+	if !p.failover {
+		start = int((p.next.Add(1) - 1) % uint64(n))
+	}
+	for i := 0; i < n; i++ {
+		idx := (start + i) % n
+		if p.healthy[idx].Load() {
+			return p.proxies[idx]
+		}
+	}
+	return p.proxies[start]
+}
+
+// startHealthChecks 定期探测代理池中各代理的 TCP 连通性，interval 为 0 时不探测
+func (r *egressRouter) startHealthChecks(ctx context.Context, interval, timeout time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	for i := range r.rules {
+		pool := r.rules[i].pool
+		if len(pool.proxies) == 0 {
+			continue
+		}
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				pool.checkHealth(ctx, timeout)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+}
+
+// checkHealth 探测一轮代理池，状态变化时记录日志
+func (p *proxyPool) checkHealth(ctx context.Context, timeout time.Duration) {
+	dialer := &net.Dialer{Timeout: timeout}
+	for i, proxy := range p.proxies {
+		port := proxy.Port()
+		if port == "" {
+			port = defaultProxyPorts[proxy.Scheme]
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(proxy.Hostname(), port))
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			conn.Close()
+		}
+		if p.healthy[i].Swap(err == nil) != (err == nil) {
+			if err != nil {
+				log.Printf("出站代理 %s 不可用: %v", proxy.Redacted(), err)
+			} else {
+				log.Printf("出站代理 %s 已恢复", proxy.Redacted())
+			}
+		}
+	}
+}
+
+// initEgress 按当前配置重建出站路由，并停止上一轮的健康检查
+func initEgress(cfg *config.AppConfig) *egressRouter {
+	router := newEgressRouter(cfg)
+	interval, _ := time.ParseDuration(cfg.Egress.HealthCheckInterval)
+	timeout, err := time.ParseDuration(cfg.Egress.HealthCheckTimeout)
+	if err != nil || timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	ctx, cancel := context.WithCancel(BackgroundContext())
+	router.cancel = cancel
+	router.startHealthChecks(ctx, interval, timeout)
+
+	currentEgressMu.Lock()
+	if currentEgress != nil {
+		currentEgress.cancel()
+	}
+	currentEgress = router
+	currentEgressMu.Unlock()
+	return router
+}
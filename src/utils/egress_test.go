@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"hubproxy/config"
+)
+
+func TestEgressRuleSelection(t *testing.T) {
+	loadTLSTestConfig(t, `
+[access]
+proxy = "socks5://127.0.0.1:1080"
+
+[registries."harbor.local"]
+upstream = "harbor.local:8443/mirror"
+authHost = "auth.harbor.local/service/token"
+enabled = true
+
+[egress]
+healthCheckInterval = "0s"
+
+[[egress.rules]]
+hosts = ["*.gcr.io"]
+proxies = ["http://10.0.0.1:3128", "10.0.0.2:3128"]
+
+[[egress.rules]]
+registries = ["harbor.local", "docker.io"]
+`)
+	router := newEgressRouter(config.GetConfig())
+
+	cases := []struct {
+		host string
+		want string
+	}{
+		{"k8s.gcr.io", "http://10.0.0.1:3128"},
+		{"k8s.gcr.io", "http://10.0.0.2:3128"},
+		{"K8S.GCR.IO", "http://10.0.0.1:3128"},
+		{"harbor.local:8443", ""},
+		{"auth.harbor.local", ""},
+		{"registry-1.docker.io", ""},
+		{"production.cloudflare.docker.com", ""},
+		{"github.com", "socks5://127.0.0.1:1080"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "https://"+tc.host+"/v2/", nil)
+		proxy, err := router.proxyFor(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.host, err)
+		}
+		got := ""
+		if proxy != nil {
+			got = proxy.String()
+		}
+		if got != tc.want {
+			t.Errorf("%s: proxy = %q, want %q", tc.host, got, tc.want)
+		}
+	}
+}
+
+func TestEgressFailoverSkipsUnhealthyProxy(t *testing.T) {
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := dead.Addr().String()
+	dead.Close()
+
+	proxied := make(chan string, 1)
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r.URL.String()
+		io.WriteString(w, "via proxy")
+	}))
+	defer live.Close()
+
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("HTTPS_PROXY", "")
+	loadTLSTestConfig(t, `
+[egress]
+healthCheckInterval = "50ms"
+healthCheckTimeout = "1s"
+
+[[egress.rules]]
+hosts = ["upstream.test"]
+proxies = ["http://`+deadAddr+`", "`+live.URL+`"]
+strategy = "failover"
+`)
+	InitHTTPClients()
+	router := currentEgress
+	t.Cleanup(router.cancel)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for router.rules[0].pool.healthy[0].Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("dead proxy was never marked unhealthy")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	resp, err := GetGlobalHTTPClient().Get("http://upstream.test/v2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "via proxy" {
+		t.Fatalf("body = %q, want response from live proxy", body)
+	}
+	if got := <-proxied; got != "http://upstream.test/v2/" {
+		t.Fatalf("proxy saw %q, want absolute upstream URL", got)
+	}
+	if os.Getenv("HTTP_PROXY") != "" || os.Getenv("HTTPS_PROXY") != "" {
+		t.Fatal("InitHTTPClients must not mutate proxy environment variables")
+	}
+}
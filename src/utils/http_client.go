@@ -1,31 +1,48 @@
 package utils
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"hubproxy/config"
 )
 
+// hopByHopHeaders 逐跳头只对当前连接有效，代理转发时必须丢弃（RFC 9110 7.6.1）
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
 var (
 	globalHTTPClient *http.Client
 	searchHTTPClient *http.Client
+
+	// registryTransports 按 TLS 设置缓存的上游传输，相同设置的 Registry 共用连接池
+	registryTransports sync.Map
 )
 
-// InitHTTPClients 初始化HTTP客户端
+// InitHTTPClients 初始化HTTP客户端，出站代理按 [egress] 规则逐请求选择
 func InitHTTPClients() {
-	cfg := config.GetConfig()
-
-	if p := cfg.Access.Proxy; p != "" {
-		os.Setenv("HTTP_PROXY", p)
-		os.Setenv("HTTPS_PROXY", p)
-	}
+	egress := initEgress(config.GetConfig())
 
 	globalHTTPClient = &http.Client{
 		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
+			Proxy: egress.proxyFor,
 			DialContext: (&net.Dialer{
 				Timeout:   30 * time.Second,
 				KeepAlive: 30 * time.Second,
@@ -39,10 +56,12 @@ func InitHTTPClients() {
 		},
 	}
 
+	registryTransports.Clear()
+
 	searchHTTPClient = &http.Client{
 		Timeout: 10 * time.Second,
 		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
+			Proxy: egress.proxyFor,
 			DialContext: (&net.Dialer{
 				Timeout:   5 * time.Second,
 				KeepAlive: 30 * time.Second,
@@ -65,3 +84,90 @@ func GetGlobalHTTPClient() *http.Client {
 func GetSearchHTTPClient() *http.Client {
 	return searchHTTPClient
 }
+
+// RegistryTransport 按 Registry 映射的 CA、客户端证书与 insecureSkipVerify 返回上游传输，未配置时使用全局传输
+func RegistryTransport(mapping config.RegistryMapping) (http.RoundTripper, error) {
+	if mapping.CAFile == "" && mapping.CertFile == "" && mapping.KeyFile == "" && !mapping.InsecureSkipVerify {
+		return globalHTTPClient.Transport, nil
+	}
+
+	key := strings.Join([]string{mapping.CAFile, mapping.CertFile, mapping.KeyFile, strconv.FormatBool(mapping.InsecureSkipVerify)}, "|")
+	if cached, ok := registryTransports.Load(key); ok {
+		return cached.(http.RoundTripper), nil
+	}
+
+	tlsConfig, err := registryTLSConfig(mapping)
+	if err != nil {
+		return nil, err
+	}
+	transport := globalHTTPClient.Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	actual, _ := registryTransports.LoadOrStore(key, transport)
+	return actual.(http.RoundTripper), nil
+}
+
+// registryTLSConfig 在系统根证书之外追加 CA 文件，并加载 mTLS 客户端证书
+func registryTLSConfig(mapping config.RegistryMapping) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: mapping.InsecureSkipVerify}
+
+	if mapping.CAFile != "" {
+		pool, err := loadCAPool(mapping.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if mapping.CertFile != "" || mapping.KeyFile != "" {
+		if mapping.CertFile == "" || mapping.KeyFile == "" {
+			return nil, fmt.Errorf("certFile 与 keyFile 需同时配置")
+		}
+		cert, err := tls.LoadX509KeyPair(mapping.CertFile, mapping.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载客户端证书失败: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// loadCAPool 返回系统根证书加上 caFile 中证书的证书池
+func loadCAPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取 CA 文件失败: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("CA 文件 %s 中没有有效的 PEM 证书", caFile)
+	}
+	return pool, nil
+}
+
+// CopyEndToEndHeaders 复制 src 中的端到端头到 dst，跳过逐跳头以及 Connection 中声明的头
+func CopyEndToEndHeaders(dst, src http.Header) {
+	skip := make(map[string]bool, len(hopByHopHeaders))
+	for _, key := range hopByHopHeaders {
+		skip[key] = true
+	}
+	for _, value := range src.Values("Connection") {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				skip[http.CanonicalHeaderKey(field)] = true
+			}
+		}
+	}
+	for key, values := range src {
+		if skip[http.CanonicalHeaderKey(key)] {
+			continue
+		}
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// backgroundCtx 后台任务（缓存清理、tag 监视、预拉取等）的生命周期，StopBackground 后随之退出
+var backgroundCtx, stopBackground = context.WithCancel(context.Background())
+
+// BackgroundContext 返回后台任务应使用的 context
+func BackgroundContext() context.Context {
+	return backgroundCtx
+}
+
+// StopBackground 通知所有后台任务退出
+func StopBackground() {
+	stopBackground()
+}
+
+// transferPollInterval WaitTransfers 检查进行中传输的间隔
+const transferPollInterval = 100 * time.Millisecond
+
+var (
+	draining        atomic.Bool
+	activeTransfers atomic.Int64
+)
+
+// SetDraining 标记服务是否处于优雅关闭阶段，关闭期间 /ready 返回未就绪
+func SetDraining(value bool) {
+	draining.Store(value)
+}
+
+// IsDraining 服务是否正在优雅关闭
+func IsDraining() bool {
+	return draining.Load()
+}
+
+// BeginTransfer 登记一个进行中的长传输（blob、GitHub 文件、镜像 tar），返回的函数在传输结束时调用
+func BeginTransfer() func() {
+	activeTransfers.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() { activeTransfers.Add(-1) })
+	}
+}
+
+// ActiveTransfers 返回进行中的传输数量
+func ActiveTransfers() int64 {
+	return activeTransfers.Load()
+}
+
+// WaitTransfers 等待所有传输结束，ctx 先结束时返回 false
+func WaitTransfers(ctx context.Context) bool {
+	ticker := time.NewTicker(transferPollInterval)
+	defer ticker.Stop()
+	for activeTransfers.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// 缓存命名空间
+const (
+	CacheNamespaceTokens    = "tokens"
+	CacheNamespaceManifests = "manifests"
+	CacheNamespaceSearch    = "search"
+	CacheNamespaceTags      = "tags"
+	CacheNamespaceDefault   = "default"
+)
+
+// cacheEntryOverhead 每个条目的额外内存估算（链表节点、map 槽位、时间戳等）
+const cacheEntryOverhead = 128
+
+// CacheStats 命名空间的缓存统计
+type CacheStats struct {
+	Entries    int   `json:"entries"`
+	Bytes      int64 `json:"bytes"`
+	QuotaBytes int64 `json:"quota_bytes,omitempty"`
+	Hits       int64 `json:"hits"`
+	Misses     int64 `json:"misses"`
+	Evictions  int64 `json:"evictions"`
+}
+
+type lruEntry struct {
+	namespace  string
+	key        string
+	value      interface{}
+	size       int64
+	expiresAt  time.Time
+	lastAccess uint64
+}
+
+// lruNamespace 命名空间独立的 LRU 链表，表头为最近使用
+type lruNamespace struct {
+	order *list.List
+	stats CacheStats
+}
+
+// LRUCache 按字节预算淘汰的 LRU 缓存，每个命名空间可单独设置配额
+type LRUCache struct {
+	mu         sync.Mutex
+	maxBytes   int64
+	quotas     map[string]int64
+	usedBytes  int64
+	items      map[string]*list.Element
+	namespaces map[string]*lruNamespace
+	tick       uint64
+}
+
+// NewLRUCache 创建缓存，maxBytes 为总预算，quotas 为各命名空间上限（字节）
+func NewLRUCache(maxBytes int64, quotas map[string]int64) *LRUCache {
+	c := &LRUCache{
+		items:      make(map[string]*list.Element),
+		namespaces: make(map[string]*lruNamespace),
+	}
+	c.Configure(maxBytes, quotas)
+	return c
+}
+
+// Configure 调整总预算与命名空间配额，超出部分立即淘汰
+func (c *LRUCache) Configure(maxBytes int64, quotas map[string]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxBytes = maxBytes
+	c.quotas = make(map[string]int64, len(quotas))
+	for ns, quota := range quotas {
+		if quota > 0 {
+			c.quotas[ns] = quota
+		}
+	}
+	for ns := range c.namespaces {
+		c.enforceQuotaLocked(ns)
+	}
+	c.enforceBudgetLocked()
+}
+
+func itemKey(namespace, key string) string {
+	return namespace + "\x00" + key
+}
+
+func (c *LRUCache) namespaceLocked(ns string) *lruNamespace {
+	n, ok := c.namespaces[ns]
+	if !ok {
+		n = &lruNamespace{order: list.New()}
+		c.namespaces[ns] = n
+	}
+	return n
+}
+
+// Get 获取条目并刷新最近使用时间，过期条目同样返回，由调用方决定是否使用；未过期计为命中
+func (c *LRUCache) Get(namespace, key string) (interface{}, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := c.namespaceLocked(namespace)
+	elem, ok := c.items[itemKey(namespace, key)]
+	if !ok {
+		n.stats.Misses++
+		return nil, time.Time{}, false
+	}
+
+	entry := elem.Value.(*lruEntry)
+	if time.Now().Before(entry.expiresAt) {
+		n.stats.Hits++
+	} else {
+		n.stats.Misses++
+	}
+	c.tick++
+	entry.lastAccess = c.tick
+	n.order.MoveToFront(elem)
+	return entry.value, entry.expiresAt, true
+}
+
+// Set 写入条目，size 为估算的字节数；超过配额或总预算时淘汰最久未使用的条目
+func (c *LRUCache) Set(namespace, key string, value interface{}, size int64, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := itemKey(namespace, key)
+	if elem, ok := c.items[id]; ok {
+		c.removeLocked(elem)
+	}
+
+	size += int64(len(key)) + cacheEntryOverhead
+	if (c.maxBytes > 0 && size > c.maxBytes) || (c.quotas[namespace] > 0 && size > c.quotas[namespace]) {
+		return
+	}
+
+	n := c.namespaceLocked(namespace)
+	c.tick++
+	elem := n.order.PushFront(&lruEntry{
+		namespace:  namespace,
+		key:        key,
+		value:      value,
+		size:       size,
+		expiresAt:  time.Now().Add(ttl),
+		lastAccess: c.tick,
+	})
+	c.items[id] = elem
+	n.stats.Entries++
+	n.stats.Bytes += size
+	c.usedBytes += size
+
+	c.enforceQuotaLocked(namespace)
+	c.enforceBudgetLocked()
+}
+
+// Delete 删除条目
+func (c *LRUCache) Delete(namespace, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[itemKey(namespace, key)]; ok {
+		c.removeLocked(elem)
+	}
+}
+
+// RemoveExpired 删除过期超过 grace 的条目，指定 namespaces 时只清理这些命名空间，返回删除数量
+func (c *LRUCache) RemoveExpired(grace time.Duration, namespaces ...string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deadline := time.Now().Add(-grace)
+	removed := 0
+	for ns, n := range c.namespaces {
+		if len(namespaces) > 0 && !containsString(namespaces, ns) {
+			continue
+		}
+		for elem := n.order.Front(); elem != nil; {
+			next := elem.Next()
+			if entry := elem.Value.(*lruEntry); entry.expiresAt.Before(deadline) {
+				c.removeLocked(elem)
+				removed++
+			}
+			elem = next
+		}
+	}
+	return removed
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats 返回各命名空间的统计
+func (c *LRUCache) Stats() map[string]CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make(map[string]CacheStats, len(c.namespaces))
+	for ns, n := range c.namespaces {
+		s := n.stats
+		s.QuotaBytes = c.quotas[ns]
+		stats[ns] = s
+	}
+	return stats
+}
+
+// Usage 返回已用字节与总预算
+func (c *LRUCache) Usage() (int64, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.usedBytes, c.maxBytes
+}
+
+func (c *LRUCache) removeLocked(elem *list.Element) {
+	entry := elem.Value.(*lruEntry)
+	n := c.namespaces[entry.namespace]
+	n.order.Remove(elem)
+	delete(c.items, itemKey(entry.namespace, entry.key))
+	n.stats.Entries--
+	n.stats.Bytes -= entry.size
+	c.usedBytes -= entry.size
+}
+
+func (c *LRUCache) evictLocked(elem *list.Element) {
+	entry := elem.Value.(*lruEntry)
+	c.namespaces[entry.namespace].stats.Evictions++
+	c.removeLocked(elem)
+}
+
+// enforceQuotaLocked 命名空间超出配额时从其链表尾部淘汰
+func (c *LRUCache) enforceQuotaLocked(ns string) {
+	quota := c.quotas[ns]
+	n, ok := c.namespaces[ns]
+	if quota <= 0 || !ok {
+		return
+	}
+	for n.stats.Bytes > quota {
+		c.evictLocked(n.order.Back())
+	}
+}
+
+// enforceBudgetLocked 超出总预算时淘汰所有命名空间中最久未使用的条目
+func (c *LRUCache) enforceBudgetLocked() {
+	if c.maxBytes <= 0 {
+		return
+	}
+	for c.usedBytes > c.maxBytes {
+		var oldest *list.Element
+		for _, n := range c.namespaces {
+			back := n.order.Back()
+			if back == nil {
+				continue
+			}
+			if oldest == nil || back.Value.(*lruEntry).lastAccess < oldest.Value.(*lruEntry).lastAccess {
+				oldest = back
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		c.evictLocked(oldest)
+	}
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCacheEvictsLeastRecentlyUsedWithinBudget(t *testing.T) {
+	entrySize := int64(100 + 1 + cacheEntryOverhead)
+	cache := NewLRUCache(3*entrySize, nil)
+
+	cache.Set(CacheNamespaceManifests, "a", "A", 100, time.Minute)
+	cache.Set(CacheNamespaceManifests, "b", "B", 100, time.Minute)
+	cache.Set(CacheNamespaceTokens, "c", "C", 100, time.Minute)
+	if _, _, ok := cache.Get(CacheNamespaceManifests, "a"); !ok {
+		t.Fatal("a missing")
+	}
+
+	cache.Set(CacheNamespaceTokens, "d", "D", 100, time.Minute)
+	if _, _, ok := cache.Get(CacheNamespaceManifests, "b"); ok {
+		t.Fatal("least recently used entry b not evicted")
+	}
+	for _, id := range [][2]string{{CacheNamespaceManifests, "a"}, {CacheNamespaceTokens, "c"}, {CacheNamespaceTokens, "d"}} {
+		if _, _, ok := cache.Get(id[0], id[1]); !ok {
+			t.Fatalf("%s/%s evicted", id[0], id[1])
+		}
+	}
+
+	used, _ := cache.Usage()
+	if used != 3*entrySize {
+		t.Fatalf("used bytes = %d, want %d", used, 3*entrySize)
+	}
+	stats := cache.Stats()[CacheNamespaceManifests]
+	if stats.Evictions != 1 || stats.Misses != 1 || stats.Hits != 2 {
+		t.Fatalf("manifest stats = %#v", stats)
+	}
+}
+
+func TestLRUCacheNamespaceQuota(t *testing.T) {
+	entrySize := int64(10 + 1 + cacheEntryOverhead)
+	cache := NewLRUCache(0, map[string]int64{CacheNamespaceSearch: 2 * entrySize})
+
+	cache.Set(CacheNamespaceSearch, "1", 1, 10, time.Minute)
+	cache.Set(CacheNamespaceSearch, "2", 2, 10, time.Minute)
+	cache.Set(CacheNamespaceSearch, "3", 3, 10, time.Minute)
+	cache.Set(CacheNamespaceTags, "4", 4, 10, time.Minute)
+
+	if _, _, ok := cache.Get(CacheNamespaceSearch, "1"); ok {
+		t.Fatal("quota not enforced")
+	}
+	stats := cache.Stats()
+	if stats[CacheNamespaceSearch].Entries != 2 || stats[CacheNamespaceTags].Entries != 1 {
+		t.Fatalf("stats = %#v", stats)
+	}
+
+	cache.Set(CacheNamespaceSearch, "huge", 0, 10*entrySize, time.Minute)
+	if _, _, ok := cache.Get(CacheNamespaceSearch, "huge"); ok {
+		t.Fatal("entry larger than quota stored")
+	}
+}
+
+func TestLRUCacheRemoveExpired(t *testing.T) {
+	cache := NewLRUCache(0, nil)
+	cache.Set(CacheNamespaceSearch, "old", 1, 1, -time.Hour)
+	cache.Set(CacheNamespaceTokens, "stale", 1, 1, -time.Minute)
+	cache.Set(CacheNamespaceTokens, "fresh", 1, 1, time.Minute)
+
+	if removed := cache.RemoveExpired(0, CacheNamespaceSearch); removed != 1 {
+		t.Fatalf("removed = %d, want 1", removed)
+	}
+	if removed := cache.RemoveExpired(10 * time.Minute); removed != 0 {
+		t.Fatalf("stale entry within grace removed")
+	}
+	if removed := cache.RemoveExpired(0); removed != 1 {
+		t.Fatalf("removed = %d, want 1", removed)
+	}
+	if _, _, ok := cache.Get(CacheNamespaceTokens, "fresh"); !ok {
+		t.Fatal("fresh entry removed")
+	}
+}
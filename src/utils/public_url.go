@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"hubproxy/config"
+)
+
+// PublicBaseURL 返回客户端访问本服务使用的外部地址（scheme://host[/basePath]，不含末尾斜杠）。
+// 优先使用 server.publicURL；否则仅信任来自可信反代的 Forwarded / X-Forwarded-* 头，最后按本次连接推断。
+func PublicBaseURL(c *gin.Context) string {
+	cfg := config.GetConfig()
+	base := strings.TrimRight(strings.TrimSpace(cfg.Server.PublicURL), "/")
+	if base == "" {
+		base = requestOrigin(c, cfg)
+	}
+	if cfg.Server.BasePath != "" && !strings.HasSuffix(base, cfg.Server.BasePath) {
+		base += cfg.Server.BasePath
+	}
+	return base
+}
+
+// requestOrigin 按可信反代头或本次连接推断 scheme://host
+func requestOrigin(c *gin.Context, cfg *config.AppConfig) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	host := c.Request.Host
+
+	if isTrustedProxy(c.RemoteIP()) {
+		proto, fwdHost := parseForwardedHeader(c.GetHeader("Forwarded"))
+		if proto == "" {
+			proto = firstHeaderValue(c.GetHeader("X-Forwarded-Proto"))
+		}
+		if fwdHost == "" {
+			fwdHost = firstHeaderValue(c.GetHeader("X-Forwarded-Host"))
+		}
+		if proto = strings.ToLower(proto); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if fwdHost != "" {
+			host = fwdHost
+		}
+	}
+
+	if host == "" {
+		host = net.JoinHostPort(cfg.Server.Host, strconv.Itoa(cfg.Server.Port))
+		if cfg.Server.Host == "" || cfg.Server.Host == "0.0.0.0" {
+			host = net.JoinHostPort("localhost", strconv.Itoa(cfg.Server.Port))
+		}
+	}
+	return scheme + "://" + host
+}
+
+// isTrustedProxy 判断直连地址是否属于可信反代网段
+func isTrustedProxy(remoteIP string) bool {
+	ip := net.ParseIP(remoteIP)
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range trustedProxyCIDRs {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwardedHeader 解析 RFC 7239 Forwarded 头第一跳的 proto 与 host
+func parseForwardedHeader(value string) (proto, host string) {
+	if value == "" {
+		return "", ""
+	}
+	first, _, _ := strings.Cut(value, ",")
+	for _, pair := range strings.Split(first, ";") {
+		key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		val = strings.Trim(strings.TrimSpace(val), `"`)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "proto":
+			proto = val
+		case "host":
+			host = val
+		}
+	}
+	return proto, host
+}
+
+// firstHeaderValue 取多级反代逗号拼接头中最靠近客户端的一项
+func firstHeaderValue(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	return strings.TrimSpace(first)
+}
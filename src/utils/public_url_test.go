@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"hubproxy/config"
+)
+
+func TestPublicBaseURL(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	newContext := func(remoteAddr string, headers map[string]string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/token", nil)
+		c.Request.Host = "hub.internal:5000"
+		c.Request.RemoteAddr = remoteAddr
+		for k, v := range headers {
+			c.Request.Header.Set(k, v)
+		}
+		return c
+	}
+
+	cases := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"direct", "203.0.113.5:1234", nil, "http://hub.internal:5000"},
+		{"untrusted forwarded", "203.0.113.5:1234", map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example"}, "http://hub.internal:5000"},
+		{"trusted x-forwarded", "10.0.0.2:1234", map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "hub.example.com"}, "https://hub.example.com"},
+		{"trusted forwarded", "127.0.0.1:1234", map[string]string{"Forwarded": `for=198.51.100.1;proto=https;host="mirror.example.com", for=10.0.0.1`}, "https://mirror.example.com"},
+		{"trusted proto only", "192.168.1.2:1234", map[string]string{"X-Forwarded-Proto": "https, http"}, "https://hub.internal:5000"},
+	}
+	for _, tc := range cases {
+		if got := PublicBaseURL(newContext(tc.remote, tc.headers)); got != tc.want {
+			t.Errorf("%s: got %q want %q", tc.name, got, tc.want)
+		}
+	}
+
+	c := newContext("203.0.113.5:1234", nil)
+	c.Request.TLS = &tls.ConnectionState{}
+	if got := PublicBaseURL(c); got != "https://hub.internal:5000" {
+		t.Errorf("tls: got %q", got)
+	}
+
+	if err := os.WriteFile(path, []byte("[server]\npublicURL = \"https://hub.example.com/\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	got := PublicBaseURL(newContext("10.0.0.2:1234", map[string]string{"X-Forwarded-Host": "other.example"}))
+	if got != "https://hub.example.com" {
+		t.Errorf("publicURL: got %q", got)
+	}
+}
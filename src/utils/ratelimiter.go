@@ -15,6 +15,13 @@ import (
 const (
 	CleanupInterval = 20 * time.Minute
 	MaxIPCacheSize  = 10000
+	// banNotifyWindow 同一 IP 的 ip.banned 事件通知间隔
+	banNotifyWindow = 10 * time.Minute
+)
+
+var (
+	bannedIPNotified   = make(map[string]time.Time)
+	bannedIPNotifiedMu sync.Mutex
 )
 
 // 可信反代
@@ -105,7 +112,12 @@ func (i *IPRateLimiter) cleanupRoutine() {
 	ticker := time.NewTicker(CleanupInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ticker.C:
+		case <-backgroundCtx.Done():
+			return
+		}
 		now := time.Now()
 		expired := make([]string, 0)
 
@@ -237,6 +249,7 @@ func RateLimitMiddleware(limiter *IPRateLimiter) gin.HandlerFunc {
 		ipLimiter, allowed := limiter.GetLimiter(cleanIP)
 
 		if !allowed {
+			notifyIPBanned(cleanIP, "blacklist")
 			c.JSON(403, gin.H{
 				"error": "您已被限制访问",
 			})
@@ -245,6 +258,7 @@ func RateLimitMiddleware(limiter *IPRateLimiter) gin.HandlerFunc {
 		}
 
 		if !ipLimiter.Allow() {
+			notifyIPBanned(cleanIP, "rate_limit")
 			c.JSON(429, gin.H{
 				"error": "请求频率过快，暂时限制访问",
 			})
@@ -255,3 +269,28 @@ func RateLimitMiddleware(limiter *IPRateLimiter) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// notifyIPBanned 发出 ip.banned 事件，同一 IP 在 banNotifyWindow 内只通知一次
+func notifyIPBanned(ip, reason string) {
+	if len(config.GetConfig().Webhooks) == 0 {
+		return
+	}
+
+	now := time.Now()
+	bannedIPNotifiedMu.Lock()
+	if last, ok := bannedIPNotified[ip]; ok && now.Sub(last) < banNotifyWindow {
+		bannedIPNotifiedMu.Unlock()
+		return
+	}
+	if len(bannedIPNotified) >= MaxIPCacheSize {
+		for key, last := range bannedIPNotified {
+			if now.Sub(last) >= banNotifyWindow {
+				delete(bannedIPNotified, key)
+			}
+		}
+	}
+	bannedIPNotified[ip] = now
+	bannedIPNotifiedMu.Unlock()
+
+	EmitEvent(EventIPBanned, map[string]string{"ip": ip, "reason": reason})
+}
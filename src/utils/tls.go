@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"hubproxy/config"
+)
+
+// certCheckInterval 静态证书文件的变更检查间隔
+var certCheckInterval = 10 * time.Second
+
+// TLSSetup 原生 TLS 终止所需的证书配置，ACME 模式下还负责应答 HTTP-01 质询
+type TLSSetup struct {
+	Config *tls.Config
+	acme   *autocert.Manager
+}
+
+// NewTLSSetup 按 server.tls 加载静态证书或初始化 ACME 证书管理
+func NewTLSSetup() (*TLSSetup, error) {
+	tlsCfg := config.GetConfig().Server.TLS
+
+	if tlsCfg.ACME.Enabled {
+		manager, err := newACMEManager(tlsCfg.ACME)
+		if err != nil {
+			return nil, err
+		}
+		// autocert 的 TLSConfig 已包含 h2、http/1.1 与 TLS-ALPN-01 使用的 acme-tls/1
+		tlsConfig := manager.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		return &TLSSetup{Config: tlsConfig, acme: manager}, nil
+	}
+
+	reloader, err := newCertReloader(tlsCfg.CertFile, tlsCfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &TLSSetup{Config: &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}}, nil
+}
+
+// HTTPHandler 明文端口的处理器：优先应答 ACME HTTP-01 质询，redirect 时其余请求跳转到 httpsPort，否则交给 next
+func (s *TLSSetup) HTTPHandler(next http.Handler, redirect bool, httpsPort int) http.Handler {
+	if redirect {
+		next = httpsRedirectHandler(httpsPort)
+	}
+	if s.acme != nil {
+		return s.acme.HTTPHandler(next)
+	}
+	return next
+}
+
+// newACMEManager 创建 ACME 证书管理器，只为配置的域名签发证书
+func newACMEManager(acmeCfg config.ACMEConfig) (*autocert.Manager, error) {
+	client := &acme.Client{DirectoryURL: acmeCfg.DirectoryURL}
+	if acmeCfg.CAFile != "" {
+		pool, err := loadCAPool(acmeCfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client.HTTPClient = &http.Client{Transport: transport, Timeout: 30 * time.Second}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(acmeCfg.CacheDir),
+		HostPolicy: autocert.HostWhitelist(acmeCfg.Domains...),
+		Email:      acmeCfg.Email,
+		Client:     client,
+	}, nil
+}
+
+// httpsRedirectHandler 将明文请求永久跳转到同一 Host 的 HTTPS 端口
+func httpsRedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// certReloader 按文件修改时间热加载静态证书，替换证书文件后无需重启
+type certReloader struct {
+	certFile  string
+	keyFile   string
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, checkedAt: time.Now()}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// latestModTime 返回证书与私钥中较新的修改时间
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return fmt.Errorf("读取 TLS 证书失败: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("加载 TLS 证书失败: %w", err)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// GetCertificate 供 tls.Config 使用，定期检查证书文件是否更新，重新加载失败时继续使用旧证书
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checkedAt) >= certCheckInterval {
+		r.checkedAt = time.Now()
+		if modTime, err := r.latestModTime(); err == nil && !modTime.Equal(r.modTime) {
+			if err := r.reload(); err != nil {
+				log.Printf("%v，继续使用旧证书", err)
+			} else {
+				log.Printf("TLS 证书已重新加载: %s", r.certFile)
+			}
+		}
+	}
+	return r.cert, nil
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"hubproxy/config"
+)
+
+func loadTLSTestConfig(t *testing.T, body string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStaticCertificateHotReload(t *testing.T) {
+	first, certFile, keyFile := writeTestCertificate(t)
+	second, newCert, newKey := writeTestCertificate(t)
+	loadTLSTestConfig(t, `
+[server.tls]
+enabled = true
+certFile = "`+filepath.ToSlash(certFile)+`"
+keyFile = "`+filepath.ToSlash(keyFile)+`"
+`)
+
+	setup, err := NewTLSSetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := setup.Config.GetCertificate(nil)
+	if err != nil || !bytes.Equal(got.Certificate[0], first.Certificate[0]) {
+		t.Fatalf("initial certificate mismatch: %v", err)
+	}
+
+	oldInterval := certCheckInterval
+	certCheckInterval = 0
+	defer func() { certCheckInterval = oldInterval }()
+
+	for src, dst := range map[string]string{newCert: certFile, newKey: keyFile} {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dst, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		future := time.Now().Add(time.Minute)
+		if err := os.Chtimes(dst, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err = setup.Config.GetCertificate(nil)
+	if err != nil || !bytes.Equal(got.Certificate[0], second.Certificate[0]) {
+		t.Fatalf("certificate not reloaded: %v", err)
+	}
+
+	if err := os.WriteFile(certFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(2 * time.Minute)
+	if err := os.Chtimes(certFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	got, err = setup.Config.GetCertificate(nil)
+	if err != nil || !bytes.Equal(got.Certificate[0], second.Certificate[0]) {
+		t.Fatalf("broken certificate replaced the working one: %v", err)
+	}
+}
+
+func TestACMESetupAndHTTPRedirect(t *testing.T) {
+	loadTLSTestConfig(t, `
+[server.tls]
+enabled = true
+
+[server.tls.acme]
+enabled = true
+domains = ["hub.example.com"]
+directoryURL = "https://127.0.0.1:14000/dir"
+cacheDir = "`+filepath.ToSlash(t.TempDir())+`"
+`)
+
+	setup, err := NewTLSSetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, proto := range []string{"h2", "acme-tls/1"} {
+		if !slices.Contains(setup.Config.NextProtos, proto) {
+			t.Fatalf("NextProtos %v missing %s", setup.Config.NextProtos, proto)
+		}
+	}
+
+	handler := setup.HTTPHandler(http.NotFoundHandler(), true, 8443)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://hub.example.com:8080/v2/library/nginx/manifests/latest?ns=docker.io", nil))
+	if w.Code != http.StatusPermanentRedirect {
+		t.Fatalf("status = %d, want 308", w.Code)
+	}
+	if got, want := w.Header().Get("Location"), "https://hub.example.com:8443/v2/library/nginx/manifests/latest?ns=docker.io"; got != want {
+		t.Fatalf("Location = %q, want %q", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://hub.example.com/.well-known/acme-challenge/unknown", nil))
+	if w.Code == http.StatusPermanentRedirect {
+		t.Fatal("ACME challenge path was redirected")
+	}
+
+	handler = setup.HTTPHandler(http.NotFoundHandler(), false, 443)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://hub.example.com/ready", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("redirect disabled: status = %d, want fallback 404", w.Code)
+	}
+}
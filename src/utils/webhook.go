@@ -0,0 +1,271 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"hubproxy/config"
+)
+
+// Webhook 事件类型
+const (
+	EventTagChanged        = "tag.changed"
+	EventUpstreamFailing   = "upstream.failing"
+	EventUpstreamRecovered = "upstream.recovered"
+	EventIPBanned          = "ip.banned"
+	EventExportCompleted   = "export.completed"
+)
+
+// 投递状态
+const (
+	deliveryStatusPending   = "pending"
+	deliveryStatusDelivered = "delivered"
+	deliveryStatusFailed    = "failed"
+)
+
+const (
+	defaultWebhookRetries = 3
+	defaultWebhookTimeout = 10 * time.Second
+	// maxWebhookDeliveries 投递日志保留条数
+	maxWebhookDeliveries = 200
+	// webhookConcurrency 同时进行的投递数，超出的事件直接丢弃并记录日志
+	webhookConcurrency = 16
+)
+
+// webhookRetryBaseDelay 首次重试的等待时间，之后逐次翻倍
+var webhookRetryBaseDelay = time.Second
+
+// WebhookEvent 发送给 webhook 的 JSON 负载
+type WebhookEvent struct {
+	ID   string      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// WebhookDelivery 一次事件投递的记录
+type WebhookDelivery struct {
+	EventID     string     `json:"event_id"`
+	Event       string     `json:"event"`
+	URL         string     `json:"url"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	StatusCode  int        `json:"status_code,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// webhookLog 最近的投递记录
+type webhookLog struct {
+	mu         sync.RWMutex
+	deliveries []*WebhookDelivery
+}
+
+var (
+	webhookDeliveries = &webhookLog{}
+	webhookSlots      = make(chan struct{}, webhookConcurrency)
+)
+
+func (l *webhookLog) add(d *WebhookDelivery) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.deliveries = append(l.deliveries, d)
+	if len(l.deliveries) > maxWebhookDeliveries {
+		l.deliveries = append([]*WebhookDelivery(nil), l.deliveries[len(l.deliveries)-maxWebhookDeliveries:]...)
+	}
+}
+
+func (l *webhookLog) update(fn func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fn()
+}
+
+// WebhookDeliveries 按时间倒序返回投递日志
+func WebhookDeliveries() []WebhookDelivery {
+	webhookDeliveries.mu.RLock()
+	defer webhookDeliveries.mu.RUnlock()
+	result := make([]WebhookDelivery, 0, len(webhookDeliveries.deliveries))
+	for i := len(webhookDeliveries.deliveries) - 1; i >= 0; i-- {
+		result = append(result, *webhookDeliveries.deliveries[i])
+	}
+	return result
+}
+
+// EmitEvent 异步投递事件到订阅了该类型的 webhook
+func EmitEvent(eventType string, data interface{}) {
+	emitEvent(eventType, data, 0)
+}
+
+// EmitExportEvent 投递离线导出完成事件，size 为导出字节数，用于 minExportMB 过滤
+func EmitExportEvent(size int64, data interface{}) {
+	emitEvent(EventExportCompleted, data, size)
+}
+
+func emitEvent(eventType string, data interface{}, size int64) {
+	hooks := config.GetConfig().Webhooks
+	if len(hooks) == 0 {
+		return
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return
+	}
+	event := WebhookEvent{
+		ID:   hex.EncodeToString(idBytes),
+		Type: eventType,
+		Time: time.Now().UTC(),
+		Data: data,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("序列化 webhook 事件失败: %v", err)
+		return
+	}
+
+	for _, hook := range hooks {
+		if !webhookSubscribed(hook, eventType, size) {
+			continue
+		}
+		delivery := &WebhookDelivery{
+			EventID:   event.ID,
+			Event:     eventType,
+			URL:       hook.URL,
+			Status:    deliveryStatusPending,
+			CreatedAt: time.Now(),
+		}
+		select {
+		case webhookSlots <- struct{}{}:
+		default:
+			log.Printf("webhook 投递过多，丢弃事件 %s -> %s", eventType, hook.URL)
+			continue
+		}
+		webhookDeliveries.add(delivery)
+		go func(hook config.WebhookConfig) {
+			defer func() { <-webhookSlots }()
+			deliverWebhook(hook, delivery, body)
+		}(hook)
+	}
+}
+
+// webhookSubscribed 按事件类型与导出大小过滤
+func webhookSubscribed(hook config.WebhookConfig, eventType string, size int64) bool {
+	if hook.URL == "" {
+		return false
+	}
+	if eventType == EventExportCompleted && size < int64(hook.MinExportMB)<<20 {
+		return false
+	}
+	if len(hook.Events) == 0 {
+		return true
+	}
+	for _, e := range hook.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// SignWebhookPayload 计算 X-HubProxy-Signature 头的值
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook 投递事件，网络错误、5xx、408、429 时按指数退避重试
+func deliverWebhook(hook config.WebhookConfig, delivery *WebhookDelivery, body []byte) {
+	retries := hook.MaxRetries
+	if retries == 0 {
+		retries = defaultWebhookRetries
+	}
+	if retries < 0 {
+		retries = 0
+	}
+	timeout := defaultWebhookTimeout
+	if parsed, err := time.ParseDuration(hook.Timeout); err == nil && parsed > 0 {
+		timeout = parsed
+	}
+
+	var transport http.RoundTripper = http.DefaultTransport
+	if client := GetGlobalHTTPClient(); client != nil {
+		transport = client.Transport
+	}
+	client := &http.Client{Timeout: timeout, Transport: transport}
+
+	delay := webhookRetryBaseDelay
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		statusCode, err := postWebhook(client, hook, delivery, body)
+		webhookDeliveries.update(func() {
+			delivery.Attempts = attempt + 1
+			delivery.StatusCode = statusCode
+			delivery.Error = ""
+			if err != nil {
+				delivery.Error = err.Error()
+			}
+		})
+		if err == nil {
+			finishDelivery(delivery, deliveryStatusDelivered)
+			return
+		}
+		if statusCode != 0 && statusCode < http.StatusInternalServerError &&
+			statusCode != http.StatusRequestTimeout && statusCode != http.StatusTooManyRequests {
+			break
+		}
+	}
+
+	finishDelivery(delivery, deliveryStatusFailed)
+	log.Printf("webhook 投递失败 %s -> %s: %s", delivery.Event, hook.URL, delivery.Error)
+}
+
+func postWebhook(client *http.Client, hook config.WebhookConfig, delivery *WebhookDelivery, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "hubproxy-webhook")
+	req.Header.Set("X-HubProxy-Event", delivery.Event)
+	req.Header.Set("X-HubProxy-Delivery", delivery.EventID)
+	if hook.Secret != "" {
+		req.Header.Set("X-HubProxy-Signature", SignWebhookPayload(hook.Secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook 返回状态码 %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func finishDelivery(delivery *WebhookDelivery, status string) {
+	webhookDeliveries.update(func() {
+		now := time.Now()
+		delivery.Status = status
+		delivery.CompletedAt = &now
+	})
+}
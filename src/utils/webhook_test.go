@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"hubproxy/config"
+)
+
+func TestWebhookDeliveryRetriesAndSigns(t *testing.T) {
+	var attempts atomic.Int32
+	received := make(chan WebhookEvent, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get("X-HubProxy-Signature"); got != SignWebhookPayload("s3cret", body) {
+			t.Errorf("signature = %q", got)
+		}
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event WebhookEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Error(err)
+		}
+		received <- event
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	body := `
+[[webhooks]]
+url = "` + srv.URL + `"
+secret = "s3cret"
+events = ["tag.changed", "export.completed"]
+maxRetries = 2
+minExportMB = 1
+`
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	if err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	webhookRetryBaseDelay = 10 * time.Millisecond
+
+	EmitEvent(EventIPBanned, map[string]string{"ip": "192.0.2.1"})
+	EmitExportEvent(512<<10, map[string]int{"bytes": 512 << 10})
+	EmitEvent(EventTagChanged, map[string]string{"image": "nginx:stable"})
+
+	select {
+	case event := <-received:
+		if event.Type != EventTagChanged {
+			t.Fatalf("event type = %s", event.Type)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries := WebhookDeliveries()
+		if len(deliveries) == 1 && deliveries[0].Status == deliveryStatusDelivered {
+			if deliveries[0].Attempts != 2 || deliveries[0].StatusCode != http.StatusOK {
+				t.Fatalf("delivery = %+v", deliveries[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("deliveries = %+v", deliveries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
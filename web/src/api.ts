@@ -1,3 +1,5 @@
+import { basePath } from '@/lib/utils'
+
 class ApiError extends Error {
   status: number
 
@@ -88,12 +90,12 @@ export function prepareSingleDownload(params: {
   q.set('mode', 'prepare')
   q.set('compressed', String(params.compressed))
   if (params.platform?.trim()) q.set('platform', params.platform.trim())
-  return getJSON<PrepareDownloadResponse>(`/api/image/download?${q}`)
+  return getJSON<PrepareDownloadResponse>(`${basePath}/api/image/download?${q}`)
 }
 
 export function fetchImageInfo(image: string) {
   const q = new URLSearchParams({ image })
-  return getJSON<ImageInfoResponse>(`/api/image/info?${q}`)
+  return getJSON<ImageInfoResponse>(`${basePath}/api/image/info?${q}`)
 }
 
 export function prepareBatchDownload(body: {
@@ -101,7 +103,7 @@ export function prepareBatchDownload(body: {
   platform?: string
   useCompressedLayers: boolean
 }) {
-  return getJSON<PrepareDownloadResponse>('/api/image/batch?mode=prepare', {
+  return getJSON<PrepareDownloadResponse>(`${basePath}/api/image/batch?mode=prepare`, {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify(body),
@@ -114,7 +116,7 @@ export function searchImages(q: string, page: number, pageSize = 25) {
     page: String(page),
     page_size: String(pageSize),
   })
-  return getJSON<SearchResponse>(`/api/search?${params}`)
+  return getJSON<SearchResponse>(`${basePath}/api/search?${params}`)
 }
 
 export function fetchTags(namespace: string, name: string, page: number, pageSize = 100) {
@@ -123,7 +125,7 @@ export function fetchTags(namespace: string, name: string, page: number, pageSiz
     page_size: String(pageSize),
   })
   return getJSON<TagPageResult>(
-    `/api/tags/${encodeURIComponent(namespace)}/${encodeURIComponent(name)}?${params}`,
+    `${basePath}/api/tags/${encodeURIComponent(namespace)}/${encodeURIComponent(name)}?${params}`,
   )
 }
 
@@ -2,6 +2,9 @@ import type { ClassValue } from 'clsx'
 import { clsx } from 'clsx'
 import { twMerge } from 'tailwind-merge'
 
+// 服务端在 index.html 中注入 <base href>，部署在子路径时为 "/hub" 形式，根路径为空串
+export const basePath = new URL(document.baseURI).pathname.replace(/\/+$/, '')
+
 export function cn(...inputs: ClassValue[]) {
   return twMerge(clsx(inputs))
 }
@@ -2,9 +2,10 @@ import { createRouter, createWebHistory } from 'vue-router'
 import HomePage from '@/pages/HomePage.vue'
 import ImagesPage from '@/pages/ImagesPage.vue'
 import SearchPage from '@/pages/SearchPage.vue'
+import { basePath } from '@/lib/utils'
 
 const router = createRouter({
-  history: createWebHistory(),
+  history: createWebHistory(`${basePath}/`),
   routes: [
     {
       path: '/',
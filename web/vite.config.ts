@@ -4,6 +4,8 @@ import tailwindcss from '@tailwindcss/vite'
 import path from 'node:path'
 
 export default defineConfig({
+  // 资源使用相对路径，由服务端注入的 <base href> 决定实际前缀
+  base: './',
   plugins: [vue(), tailwindcss()],
   resolve: {
     alias: {